@@ -30,8 +30,17 @@ func runRequest(t *testing.T, srv http.Handler, r *http.Request) (content []*Con
 	return content
 }
 
+func mustBootstrapApp(tb testing.TB) (App, func()) {
+	app, stop, err := bootstrapApp(DefaultAppConfig(), nil)
+	if err != nil {
+		tb.Fatalf("couldn't bootstrap the app: %v", err)
+	}
+	<-app.Readiness.Ready()
+	return app, stop
+}
+
 func TestResponseCount(t *testing.T) {
-	app, stop := bootstrapApp()
+	app, stop := mustBootstrapApp(t)
 	defer stop()
 
 	content := runRequest(t, app, SimpleContentRequest)
@@ -43,7 +52,7 @@ func TestResponseCount(t *testing.T) {
 }
 
 func BenchmarkResponse(b *testing.B) {
-	app, stop := bootstrapApp()
+	app, stop := mustBootstrapApp(b)
 	defer stop()
 
 	for i := 0; i < b.N; i++ {
@@ -52,7 +61,7 @@ func BenchmarkResponse(b *testing.B) {
 }
 
 func TestResponseOrder(t *testing.T) {
-	app, stop := bootstrapApp()
+	app, stop := mustBootstrapApp(t)
 	defer stop()
 
 	content := runRequest(t, app, SimpleContentRequest)
@@ -72,7 +81,7 @@ func TestResponseOrder(t *testing.T) {
 }
 
 func TestOffsetResponseOrder(t *testing.T) {
-	app, stop := bootstrapApp()
+	app, stop := mustBootstrapApp(t)
 	defer stop()
 
 	content := runRequest(t, app, OffsetContentRequest)
@@ -91,3 +100,70 @@ func TestOffsetResponseOrder(t *testing.T) {
 		}
 	}
 }
+
+func TestFailurePolicyHeader(t *testing.T) {
+	state := &inMemoryState{
+		content: map[Provider][]*ContentItem{Provider1: {{ID: "1"}, {ID: "2"}}},
+		failing: map[Provider]bool{Provider2: true, Provider3: true},
+	}
+	sequencer := MakeConfiguredSequencer(ContentMix{
+		{Type: Provider1},
+		{Type: Provider2, OnFailure: PolicySkip},
+		{Type: Provider3},
+	})
+	app := App{Service: MakeService(testCacher{state: state}, sequencer)}
+
+	response := httptest.NewRecorder()
+	app.ServeHTTP(response, httptest.NewRequest("GET", "/?offset=0&count=5", nil))
+	if response.Code != 200 {
+		t.Fatalf("Response code is %d, want 200", response.Code)
+	}
+	if policy := response.Header().Get(failurePolicyHeader); policy != "skip, truncate" {
+		t.Errorf("Got the failure policy %q, want %q", policy, "skip, truncate")
+	}
+
+	response = httptest.NewRecorder()
+	app.ServeHTTP(response, httptest.NewRequest("GET", "/?offset=0&count=1", nil))
+	if policy := response.Header().Get(failurePolicyHeader); policy != "" {
+		t.Errorf("Got the failure policy %q for the full page", policy)
+	}
+
+	app = App{Service: MakeService(testCacher{state: &inMemoryState{
+		content: map[Provider][]*ContentItem{Provider1: {{ID: "1"}, {ID: "2"}}},
+	}}, MakeConfiguredSequencer(ContentMix{{Type: Provider1}}))}
+	response = httptest.NewRecorder()
+	app.ServeHTTP(response, httptest.NewRequest("GET", "/?offset=0&count=5", nil))
+	if policy := response.Header().Get(failurePolicyHeader); policy != "" {
+		t.Errorf("Got the failure policy %q for the end of the content", policy)
+	}
+}
+
+func TestCursorPagination(t *testing.T) {
+	app, stop := mustBootstrapApp(t)
+	defer stop()
+
+	response := httptest.NewRecorder()
+	app.ServeHTTP(response, SimpleContentRequest)
+	cursor := response.Header().Get(nextCursorHeader)
+	if cursor == "" {
+		t.Fatal("No next cursor for the full page")
+	}
+
+	next := runRequest(t, app, httptest.NewRequest("GET", "/?count=5&cursor="+cursor, nil))
+	expected := runRequest(t, app, OffsetContentRequest)
+	if len(next) != 5 || next[0].ID != expected[0].ID {
+		t.Errorf("Got %v by the cursor instead of %v", next, expected)
+	}
+
+	response = httptest.NewRecorder()
+	app.ServeHTTP(response, httptest.NewRequest("GET", "/?count=5&cursor="+Cursor{Version: 1, Offset: 5}.String(), nil))
+	if response.Code != http.StatusGone {
+		t.Errorf("Response code is %d for the expired cursor, want 410", response.Code)
+	}
+
+	response = httptest.NewRecorder()
+	app.ServeHTTP(response, httptest.NewRequest("GET", "/?count=5&cursor=garbage!", nil))
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Response code is %d for the invalid cursor, want 400", response.Code)
+	}
+}
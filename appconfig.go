@@ -0,0 +1,598 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AppConfig is the configuration of the application: the providers, the content mix and the server settings.
+type AppConfig struct {
+	Listen    string                        `json:"listen"`
+	Providers map[Provider]ProviderSettings `json:"providers"`
+	// AdminListen is the address the admin endpoints are served at, apart from the content,
+	// so it can be kept off the public network. The admin endpoints are not served if empty.
+	AdminListen string `json:"admin_listen,omitempty"`
+	// DisableRootAlias stops serving the content at "/", it is served at "/v1/content" only then.
+	DisableRootAlias bool `json:"disable_root_alias,omitempty"`
+	// Strategy is the way the items are ordered: "mix" (the default) repeats the Mix,
+	// "weighted" orders the items randomly by the Weighted mix.
+	Strategy string     `json:"strategy,omitempty"`
+	Mix      ContentMix `json:"mix,omitempty"`
+	// OnFailure is the failure policy of the slots of the Mix and the Weighted mix, which do not set their own.
+	OnFailure FailurePolicy `json:"on_failure,omitempty"`
+	// Pinned are the slots laid over the Mix at the fixed positions.
+	Pinned   []PinnedSlot `json:"pinned,omitempty"`
+	Weighted *WeightedMix `json:"weighted,omitempty"`
+	// Snapshots keeps the replaced states of the content, so the cursors of the listings started with them keep working.
+	Snapshots *SnapshotSettings `json:"snapshots,omitempty"`
+	// Dedup drops the items of the same story served by several providers.
+	Dedup *DedupSettings `json:"dedup,omitempty"`
+	// Persistence saves the cached content to the file, so the restarted application serves it right away.
+	Persistence *PersistenceSettings `json:"persistence,omitempty"`
+	// Readiness tells when the application is ready to serve after the start.
+	Readiness *ReadinessSettings `json:"readiness,omitempty"`
+}
+
+// ReadinessSettings makes the application ready when Quorum providers serve the content (when every provider
+// has finished its first refresh and one serves if 0), or when the Deadline after the start has passed (no deadline if 0).
+type ReadinessSettings struct {
+	Quorum   int      `json:"quorum,omitempty"`
+	Deadline Duration `json:"deadline,omitempty"`
+}
+
+func (rs ReadinessSettings) validate(providers int) (problems []string) {
+	if rs.Quorum < 0 {
+		problems = append(problems, "readiness quorum should not be negative")
+	} else if rs.Quorum > providers {
+		problems = append(problems, "readiness quorum should not exceed the number of the providers")
+	}
+	if rs.Deadline < 0 {
+		problems = append(problems, "readiness deadline should not be negative")
+	}
+	return
+}
+
+// PersistenceSettings tells where the cached content is saved to and how often.
+type PersistenceSettings struct {
+	Path     string   `json:"path"`
+	Interval Duration `json:"interval"`
+}
+
+func (ps PersistenceSettings) validate() (problems []string) {
+	if ps.Path == "" {
+		problems = append(problems, "persistence path is empty")
+	}
+	if ps.Interval <= 0 {
+		problems = append(problems, "persistence interval should be positive")
+	}
+	return
+}
+
+// SnapshotSettings tells how many replaced states of the content are kept and for how long after the replacement.
+type SnapshotSettings struct {
+	Keep  int      `json:"keep"`
+	Grace Duration `json:"grace"`
+}
+
+func (ss SnapshotSettings) validate() (problems []string) {
+	if ss.Keep < 0 {
+		problems = append(problems, "snapshots keep should not be negative")
+	}
+	if ss.Grace < 0 {
+		problems = append(problems, "snapshots grace should not be negative")
+	}
+	return
+}
+
+// cacherOptions returns the options of the TimeExpirationCacher set in the configuration.
+func (ac AppConfig) cacherOptions() []CacherOption {
+	var opts []CacherOption
+	if ac.Snapshots != nil {
+		opts = append(opts, WithSnapshots(ac.Snapshots.Keep, time.Duration(ac.Snapshots.Grace)))
+	}
+	if ac.Dedup != nil {
+		opts = append(opts, WithDeduplicator(NewDeduplicator(*ac.Dedup)))
+	}
+	if ac.Persistence != nil {
+		opts = append(opts, WithPersistence(ac.Persistence.Path, time.Duration(ac.Persistence.Interval)))
+	}
+	if ac.Readiness != nil {
+		opts = append(opts, WithReadiness(ac.Readiness.Quorum, time.Duration(ac.Readiness.Deadline)))
+	}
+	return opts
+}
+
+// ProviderSettings describes a provider in the configuration file.
+type ProviderSettings struct {
+	// Client is the type of the client to fetch the content with: "sample", "http_json" or "feed".
+	Client          string         `json:"client"`
+	Endpoint        string         `json:"endpoint,omitempty"`
+	RefreshInterval Duration       `json:"refresh_interval"`
+	Jitter          Duration       `json:"jitter,omitempty"`
+	Length          int            `json:"length"`
+	UserIP          string         `json:"user_ip,omitempty"`
+	MaxStaleness    Duration       `json:"max_staleness,omitempty"`
+	FetchTimeout    Duration       `json:"fetch_timeout,omitempty"`
+	MinFreshShare   float64        `json:"min_fresh_share,omitempty"`
+	Retry           *RetrySettings `json:"retry,omitempty"`
+	// HTTP configures the "http_json" client.
+	HTTP *HTTPJSONSettings `json:"http,omitempty"`
+	// Feed configures the "feed" client.
+	Feed *FeedSettings `json:"feed,omitempty"`
+	// Resilience configures the middleware wrapping the client.
+	Resilience *ResilienceSettings `json:"resilience,omitempty"`
+}
+
+// ResilienceSettings configures the middleware wrapping the provider client,
+// the client is called through the breaker, the retries, the rate limit and the timeout in this order.
+type ResilienceSettings struct {
+	// Retries retries the failed call within one refresh, max_attempts is required.
+	Retries   *RetrySettings     `json:"retries,omitempty"`
+	Timeout   Duration           `json:"timeout,omitempty"`
+	Breaker   *BreakerSettings   `json:"breaker,omitempty"`
+	RateLimit *RateLimitSettings `json:"rate_limit,omitempty"`
+}
+
+// RetrySettings describes the RetryPolicy in the configuration file.
+type RetrySettings struct {
+	InitialDelay Duration `json:"initial_delay"`
+	Multiplier   float64  `json:"multiplier,omitempty"`
+	MaxDelay     Duration `json:"max_delay,omitempty"`
+	MaxAttempts  int      `json:"max_attempts,omitempty"`
+}
+
+// Duration is the time.Duration written as a string in the configuration file, e.g. "10m".
+type Duration time.Duration
+
+// UnmarshalJSON parses the duration from the string like "1m30s".
+func (d *Duration) UnmarshalJSON(bb []byte) error {
+	var s string
+	if err := json.Unmarshal(bb, &s); err != nil {
+		return fmt.Errorf("duration should be a string like \"10m\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON writes the duration as the string like "1m30s".
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// ConfigError lists all the problems found in the configuration.
+type ConfigError []string
+
+func (ce ConfigError) Error() string {
+	return "invalid configuration: " + strings.Join(ce, "; ")
+}
+
+// The strategies of ordering the items.
+const (
+	mixStrategy      = "mix"
+	weightedStrategy = "weighted"
+)
+
+// The client types of the providers.
+const (
+	sampleClient   = "sample"
+	httpJSONClient = "http_json"
+	feedClient     = "feed"
+)
+
+// DefaultAppConfig returns the configuration used when no configuration file is given.
+func DefaultAppConfig() AppConfig {
+	retry := &RetrySettings{
+		InitialDelay: Duration(time.Second),
+		Multiplier:   2,
+		MaxDelay:     Duration(time.Minute),
+		MaxAttempts:  5,
+	}
+	provider := func(refreshInterval time.Duration, length int) ProviderSettings {
+		return ProviderSettings{
+			Client:          sampleClient,
+			RefreshInterval: Duration(refreshInterval),
+			Jitter:          Duration(time.Second * 30),
+			Length:          length,
+			UserIP:          "184.22.11.68",
+			MaxStaleness:    Duration(time.Minute * 30),
+			FetchTimeout:    Duration(time.Second * 10),
+			Retry:           retry,
+		}
+	}
+	return AppConfig{
+		Listen: "127.0.0.1:8080",
+		Providers: map[Provider]ProviderSettings{
+			Provider1: provider(time.Minute*10, 300),
+			Provider2: provider(time.Minute*5, 100),
+			Provider3: provider(time.Minute*20, 100),
+		},
+		Mix: DefaultConfig,
+		Snapshots: &SnapshotSettings{
+			Keep:  10,
+			Grace: Duration(time.Minute * 10),
+		},
+	}
+}
+
+// LoadAppConfig reads and validates the configuration file.
+func LoadAppConfig(path string) (AppConfig, error) {
+	bb, err := os.ReadFile(path)
+	if err != nil {
+		return AppConfig{}, fmt.Errorf("cannot read the configuration file: %w", err)
+	}
+	return ParseAppConfig(bb)
+}
+
+// ParseAppConfig parses and validates the JSON configuration. Unknown fields are rejected.
+func ParseAppConfig(bb []byte) (AppConfig, error) {
+	var config AppConfig
+	decoder := json.NewDecoder(bytes.NewReader(bb))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return AppConfig{}, fmt.Errorf("cannot parse the configuration: %w", err)
+	}
+	if err := config.Validate(); err != nil {
+		return AppConfig{}, err
+	}
+	return config, nil
+}
+
+// Validate checks the configuration and reports all the problems found at once.
+func (ac AppConfig) Validate() error {
+	var problems ConfigError
+	if ac.Listen == "" {
+		problems = append(problems, "listen address is empty")
+	}
+	if ac.AdminListen != "" && ac.AdminListen == ac.Listen {
+		problems = append(problems, "admin listen address should differ from the listen address")
+	}
+	if len(ac.Providers) == 0 {
+		problems = append(problems, "no providers configured")
+	}
+	for _, p := range sortedProviders(ac.Providers) {
+		problems = append(problems, ac.Providers[p].validate(p)...)
+	}
+	if ac.Snapshots != nil {
+		problems = append(problems, ac.Snapshots.validate()...)
+	}
+	if ac.Dedup != nil {
+		problems = append(problems, ac.Dedup.validate(ac.Providers)...)
+	}
+	if ac.Persistence != nil {
+		problems = append(problems, ac.Persistence.validate()...)
+	}
+	if ac.Readiness != nil {
+		problems = append(problems, ac.Readiness.validate(len(ac.Providers))...)
+	}
+	if !ac.OnFailure.valid() {
+		problems = append(problems, fmt.Sprintf("unknown failure policy %q", ac.OnFailure))
+	}
+	switch ac.Strategy {
+	case "", mixStrategy:
+		if len(ac.Mix) == 0 {
+			problems = append(problems, "content mix is empty")
+		}
+		for i, cc := range ac.Mix {
+			for _, problem := range cc.validate(ac.Providers) {
+				problems = append(problems, fmt.Sprintf("mix slot %d: %s", i, problem))
+			}
+		}
+		problems = append(problems, ac.validatePinned()...)
+	case weightedStrategy:
+		if len(ac.Pinned) != 0 {
+			problems = append(problems, "pinned slots are supported by the mix strategy only")
+		}
+		if ac.Weighted == nil {
+			problems = append(problems, "weighted mix is not configured")
+		} else {
+			problems = append(problems, ac.Weighted.validate(ac.Providers)...)
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown strategy %q", ac.Strategy))
+	}
+	if len(problems) != 0 {
+		return problems
+	}
+	return nil
+}
+
+// sequencer returns the Sequencer of the configured strategy, the configuration should be valid.
+func (ac AppConfig) sequencer() Sequencer {
+	if ac.Strategy == weightedStrategy {
+		mix := *ac.Weighted
+		mix.Weights = make([]WeightedConfig, len(ac.Weighted.Weights))
+		for i, wc := range ac.Weighted.Weights {
+			mix.Weights[i] = wc
+			mix.Weights[i].OnFailure = wc.OnFailure.or(ac.OnFailure)
+		}
+		return MakeWeightedSequencer(mix)
+	}
+	return MakeConfiguredSequencer(ac.Mix.withPolicy(ac.OnFailure), ac.Pinned...)
+}
+
+// sameLayout returns if the settings of the sequencer are the same as the other ones.
+func (ac AppConfig) sameLayout(other AppConfig) bool {
+	return ac.Strategy == other.Strategy && ac.OnFailure == other.OnFailure &&
+		reflect.DeepEqual(ac.Mix, other.Mix) && reflect.DeepEqual(ac.Pinned, other.Pinned) &&
+		reflect.DeepEqual(ac.Weighted, other.Weighted)
+}
+
+func (ac AppConfig) validatePinned() (problems []string) {
+	valid := true
+	for i, ps := range ac.Pinned {
+		report := func(format string, args ...interface{}) {
+			problems = append(problems, fmt.Sprintf("pinned slot %d: ", i)+fmt.Sprintf(format, args...))
+			valid = false
+		}
+		for _, problem := range ps.validate(ac.Providers) {
+			report("%s", problem)
+		}
+		if len(ps.Positions) == 0 && ps.Every == 0 {
+			report("neither positions nor every is set")
+		}
+		if ps.Every < 0 {
+			report("every should not be negative")
+		}
+		for _, position := range ps.Positions {
+			if position < 0 {
+				report("position %d should not be negative", position)
+			}
+		}
+	}
+	if valid && len(ac.Mix) != 0 {
+		if !fitsPinnedLayout(pinnedLayout(len(ac.Mix), ac.Pinned)) {
+			problems = append(problems, fmt.Sprintf("pinned slots: laying them over the mix takes more than %d slots", maxPinnedLayout))
+		}
+	}
+	return
+}
+
+// validate checks the providers and the fallback chain of the slot are configured, and no provider is repeated in the chain.
+func (cc ContentConfig) validate(providers map[Provider]ProviderSettings) (problems []string) {
+	if _, ok := providers[cc.Type]; !ok {
+		problems = append(problems, fmt.Sprintf("unknown provider %q", cc.Type))
+	}
+	if !cc.OnFailure.valid() {
+		problems = append(problems, fmt.Sprintf("unknown failure policy %q", cc.OnFailure))
+	}
+	chain := cc.chain()
+	for i, fallback := range chain[1:] {
+		if _, ok := providers[fallback]; !ok {
+			problems = append(problems, fmt.Sprintf("unknown fallback provider %q", fallback))
+		} else if fallback == cc.Type {
+			problems = append(problems, fmt.Sprintf("provider %q falls back to itself", cc.Type))
+		} else if containsProvider(chain[1:i+1], fallback) {
+			problems = append(problems, fmt.Sprintf("fallback provider %q is repeated in the chain", fallback))
+		}
+	}
+	return
+}
+
+func containsProvider(providers []Provider, p Provider) bool {
+	for _, provider := range providers {
+		if provider == p {
+			return true
+		}
+	}
+	return false
+}
+
+func (ps ProviderSettings) validate(p Provider) (problems []string) {
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("provider %q: ", p)+fmt.Sprintf(format, args...))
+	}
+	if p == "" {
+		problems = append(problems, "provider with empty name")
+	}
+	switch ps.Client {
+	case sampleClient:
+	case httpJSONClient, feedClient:
+		if ps.Endpoint == "" {
+			report("endpoint is empty")
+		} else if u, err := url.Parse(ps.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			report("endpoint %q is not a valid http(s) URL", ps.Endpoint)
+		}
+		if ps.HTTP != nil {
+			for _, problem := range ps.HTTP.validate() {
+				report("%s", problem)
+			}
+		}
+		if ps.Feed != nil {
+			for _, problem := range ps.Feed.validate() {
+				report("%s", problem)
+			}
+		}
+	case "":
+		report("client type is empty")
+	default:
+		report("unknown client type %q", ps.Client)
+	}
+	if ps.RefreshInterval <= 0 {
+		report("refresh_interval should be positive")
+	}
+	if ps.Jitter < 0 {
+		report("jitter should not be negative")
+	}
+	if ps.Length <= 0 {
+		report("length should be positive")
+	}
+	if ps.MaxStaleness < 0 {
+		report("max_staleness should not be negative")
+	}
+	if ps.FetchTimeout < 0 {
+		report("fetch_timeout should not be negative")
+	}
+	if ps.MinFreshShare < 0 || ps.MinFreshShare > 1 {
+		report("min_fresh_share should be between 0 and 1")
+	}
+	if ps.Retry != nil {
+		for _, problem := range ps.Retry.validate("retry") {
+			report("%s", problem)
+		}
+	}
+	if ps.Resilience != nil {
+		for _, problem := range ps.Resilience.validate() {
+			report("%s", problem)
+		}
+	}
+	return
+}
+
+func (rs RetrySettings) validate(name string) (problems []string) {
+	if rs.InitialDelay <= 0 {
+		problems = append(problems, name+" initial_delay should be positive")
+	}
+	if rs.Multiplier != 0 && rs.Multiplier < 1 {
+		problems = append(problems, name+" multiplier should not be less than 1")
+	}
+	if rs.MaxDelay < 0 {
+		problems = append(problems, name+" max_delay should not be negative")
+	}
+	if rs.MaxAttempts < 0 {
+		problems = append(problems, name+" max_attempts should not be negative")
+	}
+	return
+}
+
+func (rs RetrySettings) policy() RetryPolicy {
+	return RetryPolicy{
+		InitialDelay: time.Duration(rs.InitialDelay),
+		Multiplier:   rs.Multiplier,
+		MaxDelay:     time.Duration(rs.MaxDelay),
+		MaxAttempts:  rs.MaxAttempts,
+	}
+}
+
+func (rs ResilienceSettings) validate() (problems []string) {
+	if rs.Retries != nil {
+		problems = append(problems, rs.Retries.validate("retries")...)
+		if rs.Retries.MaxAttempts == 0 {
+			problems = append(problems, "retries max_attempts should be positive")
+		}
+	}
+	if rs.Timeout < 0 {
+		problems = append(problems, "resilience timeout should not be negative")
+	}
+	if rs.Breaker != nil {
+		problems = append(problems, rs.Breaker.validate()...)
+	}
+	if rs.RateLimit != nil {
+		problems = append(problems, rs.RateLimit.validate()...)
+	}
+	return
+}
+
+// middleware builds the middleware chain, and returns the circuit breaker if it is in the chain.
+func (rs ResilienceSettings) middleware(clock Clock) ([]ClientMiddleware, *CircuitBreaker, error) {
+	var middleware []ClientMiddleware
+	var breaker *CircuitBreaker
+	if rs.Breaker != nil {
+		var err error
+		if breaker, err = NewCircuitBreaker(*rs.Breaker, clock); err != nil {
+			return nil, nil, err
+		}
+		middleware = append(middleware, breaker.Middleware())
+	}
+	if rs.Retries != nil {
+		middleware = append(middleware, RetryMiddleware(rs.Retries.policy(), clock))
+	}
+	if rs.RateLimit != nil {
+		limiter, err := NewRateLimiter(*rs.RateLimit, clock)
+		if err != nil {
+			return nil, nil, err
+		}
+		middleware = append(middleware, limiter.Middleware())
+	}
+	if rs.Timeout > 0 {
+		middleware = append(middleware, TimeoutMiddleware(time.Duration(rs.Timeout)))
+	}
+	return middleware, breaker, nil
+}
+
+// ProviderConfigs builds the configuration of the TimeExpirationCacher with the clients for all the providers.
+func (ac AppConfig) ProviderConfigs() (map[Provider]ProviderConfig, error) {
+	configs := make(map[Provider]ProviderConfig, len(ac.Providers))
+	for p, ps := range ac.Providers {
+		pc, err := ps.providerConfig(p)
+		if err != nil {
+			return nil, err
+		}
+		configs[p] = pc
+	}
+	return configs, nil
+}
+
+func (ps ProviderSettings) providerConfig(p Provider) (ProviderConfig, error) {
+	client, err := ps.client(p)
+	if err != nil {
+		return ProviderConfig{}, err
+	}
+	pc := ProviderConfig{
+		expiration:    time.Duration(ps.RefreshInterval),
+		jitter:        time.Duration(ps.Jitter),
+		maxStaleness:  time.Duration(ps.MaxStaleness),
+		fetchTimeout:  time.Duration(ps.FetchTimeout),
+		minFreshShare: ps.MinFreshShare,
+		length:        ps.Length,
+		userIp:        ps.UserIP,
+		client:        client,
+	}
+	if ps.Retry != nil {
+		pc.retry = ps.Retry.policy()
+	}
+	if ps.Resilience != nil {
+		if pc.middleware, pc.breaker, err = ps.Resilience.middleware(realClock{}); err != nil {
+			return ProviderConfig{}, fmt.Errorf("provider %q: %w", p, err)
+		}
+	}
+	return pc, nil
+}
+
+func (ps ProviderSettings) client(p Provider) (Client, error) {
+	switch ps.Client {
+	case sampleClient:
+		return SampleContentProvider{Source: p}, nil
+	case httpJSONClient:
+		var settings HTTPJSONSettings
+		if ps.HTTP != nil {
+			settings = *ps.HTTP
+		}
+		client, err := NewHTTPJSONClient(p, ps.Endpoint, settings)
+		if err != nil {
+			return nil, fmt.Errorf("provider %q: %w", p, err)
+		}
+		return client, nil
+	case feedClient:
+		var settings FeedSettings
+		if ps.Feed != nil {
+			settings = *ps.Feed
+		}
+		client, err := NewFeedClient(p, ps.Endpoint, settings)
+		if err != nil {
+			return nil, fmt.Errorf("provider %q: %w", p, err)
+		}
+		return client, nil
+	default:
+		return nil, fmt.Errorf("provider %q: unknown client type %q", p, ps.Client)
+	}
+}
+
+func sortedProviders(providers map[Provider]ProviderSettings) []Provider {
+	out := make([]Provider, 0, len(providers))
+	for p := range providers {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
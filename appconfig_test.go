@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const validConfigJSON = `{
+	"listen": "0.0.0.0:9090",
+	"providers": {
+		"1": {"client": "sample", "refresh_interval": "10m", "jitter": "30s", "length": 300, "user_ip": "184.22.11.68",
+			"max_staleness": "30m", "retry": {"initial_delay": "1s", "multiplier": 2, "max_delay": "1m", "max_attempts": 5}},
+		"2": {"client": "sample", "refresh_interval": "5m", "length": 100}
+	},
+	"mix": [
+		{"type": "1", "fallback": "2"},
+		{"type": "2"}
+	]
+}`
+
+func TestParseAppConfig(t *testing.T) {
+	t.Run("valid config", func(t *testing.T) {
+		config, err := ParseAppConfig([]byte(validConfigJSON))
+		assert.NoError(t, err)
+		assert.Equal(t, "0.0.0.0:9090", config.Listen)
+		assert.Equal(t, ContentMix{{Type: Provider1, Fallback: &Provider2}, {Type: Provider2}}, config.Mix)
+		assert.Equal(t, Duration(time.Minute*10), config.Providers[Provider1].RefreshInterval)
+		assert.Equal(t, 5, config.Providers[Provider1].Retry.MaxAttempts)
+
+		providerConfigs, err := config.ProviderConfigs()
+		assert.NoError(t, err)
+		assert.Equal(t, ProviderConfig{
+			expiration:   time.Minute * 10,
+			jitter:       time.Second * 30,
+			maxStaleness: time.Minute * 30,
+			retry: RetryPolicy{
+				InitialDelay: time.Second,
+				Multiplier:   2,
+				MaxDelay:     time.Minute,
+				MaxAttempts:  5,
+			},
+			length: 300,
+			userIp: "184.22.11.68",
+			client: SampleContentProvider{Provider1},
+		}, providerConfigs[Provider1])
+	})
+	t.Run("unknown field", func(t *testing.T) {
+		_, err := ParseAppConfig([]byte(`{"listen": "127.0.0.1:8080", "mixes": []}`))
+		assert.Error(t, err)
+	})
+	t.Run("invalid duration", func(t *testing.T) {
+		_, err := ParseAppConfig([]byte(`{"providers": {"1": {"refresh_interval": "ten minutes"}}}`))
+		assert.Error(t, err)
+	})
+	t.Run("all the problems are reported", func(t *testing.T) {
+		_, err := ParseAppConfig([]byte(`{
+			"providers": {
+				"1": {"client": "soap", "refresh_interval": "0s", "length": 0},
+				"2": {"client": "sample", "refresh_interval": "1m", "length": 10, "retry": {"initial_delay": "1s", "multiplier": 0.5}}
+			},
+			"mix": [{"type": "1", "fallback": "4"}, {"type": "5"}, {"type": "2", "fallback": "2"}]
+		}`))
+		assert.Equal(t, ConfigError{
+			"listen address is empty",
+			`provider "1": unknown client type "soap"`,
+			`provider "1": refresh_interval should be positive`,
+			`provider "1": length should be positive`,
+			`provider "2": retry multiplier should not be less than 1`,
+			`mix slot 0: unknown fallback provider "4"`,
+			`mix slot 1: unknown provider "5"`,
+			`mix slot 2: provider "2" falls back to itself`,
+		}, err)
+	})
+	t.Run("admin listen address", func(t *testing.T) {
+		config := DefaultAppConfig()
+		config.AdminListen = "127.0.0.1:8081"
+		assert.NoError(t, config.Validate())
+		config.AdminListen = config.Listen
+		assert.Equal(t, ConfigError{"admin listen address should differ from the listen address"}, config.Validate())
+	})
+	t.Run("fallback chain", func(t *testing.T) {
+		config, err := ParseAppConfig([]byte(`{
+			"listen": "127.0.0.1:8080",
+			"providers": {
+				"1": {"client": "sample", "refresh_interval": "1m", "length": 10},
+				"2": {"client": "sample", "refresh_interval": "1m", "length": 10},
+				"3": {"client": "sample", "refresh_interval": "1m", "length": 10}
+			},
+			"mix": [{"type": "1", "fallback": "2", "fallbacks": ["3"]}, {"type": "2", "fallbacks": ["3", "1"]}]
+		}`))
+		assert.NoError(t, err)
+		assert.Equal(t, []Provider{Provider1, Provider2, Provider3}, config.Mix[0].chain())
+		assert.Equal(t, []Provider{Provider2, Provider3, Provider1}, config.Mix[1].chain())
+	})
+	t.Run("invalid fallback chain", func(t *testing.T) {
+		_, err := ParseAppConfig([]byte(`{
+			"listen": "127.0.0.1:8080",
+			"providers": {
+				"1": {"client": "sample", "refresh_interval": "1m", "length": 10},
+				"2": {"client": "sample", "refresh_interval": "1m", "length": 10}
+			},
+			"mix": [{"type": "1", "fallback": "2", "fallbacks": ["2"]}, {"type": "2", "fallbacks": ["1", "2"]}, {"type": "1", "fallbacks": ["5"]}]
+		}`))
+		assert.Equal(t, ConfigError{
+			`mix slot 0: fallback provider "2" is repeated in the chain`,
+			`mix slot 1: provider "2" falls back to itself`,
+			`mix slot 2: unknown fallback provider "5"`,
+		}, err)
+	})
+	t.Run("failure policy", func(t *testing.T) {
+		config, err := ParseAppConfig([]byte(`{
+			"listen": "127.0.0.1:8080",
+			"providers": {
+				"1": {"client": "sample", "refresh_interval": "1m", "length": 10},
+				"2": {"client": "sample", "refresh_interval": "1m", "length": 10}
+			},
+			"on_failure": "skip",
+			"mix": [{"type": "1"}, {"type": "2", "on_failure": "placeholder"}]
+		}`))
+		assert.NoError(t, err)
+		assert.Equal(t, PolicySkip, config.OnFailure)
+		sequencer, ok := config.sequencer().(ConfiguredSequencer)
+		assert.True(t, ok)
+		assert.Equal(t, []slot{
+			{chain: []Provider{Provider1}, onFailure: PolicySkip},
+			{chain: []Provider{Provider2}, onFailure: PolicyPlaceholder},
+		}, sequencer.cycle)
+
+		_, err = ParseAppConfig([]byte(`{
+			"listen": "127.0.0.1:8080",
+			"providers": {"1": {"client": "sample", "refresh_interval": "1m", "length": 10}},
+			"on_failure": "retry",
+			"mix": [{"type": "1", "on_failure": "ignore"}]
+		}`))
+		assert.Equal(t, ConfigError{
+			`unknown failure policy "retry"`,
+			`mix slot 0: unknown failure policy "ignore"`,
+		}, err)
+	})
+	t.Run("snapshots", func(t *testing.T) {
+		_, err := ParseAppConfig([]byte(`{
+			"listen": "127.0.0.1:8080",
+			"providers": {"1": {"client": "sample", "refresh_interval": "1m", "length": 10}},
+			"mix": [{"type": "1"}],
+			"snapshots": {"keep": -1, "grace": "-1m"}
+		}`))
+		assert.Equal(t, ConfigError{
+			"snapshots keep should not be negative",
+			"snapshots grace should not be negative",
+		}, err)
+	})
+	t.Run("dedup", func(t *testing.T) {
+		_, err := ParseAppConfig([]byte(`{
+			"listen": "127.0.0.1:8080",
+			"providers": {"1": {"client": "sample", "refresh_interval": "1m", "length": 10}},
+			"mix": [{"type": "1"}],
+			"dedup": {"keys": ["link", "id"], "winner": "oldest", "priority": ["1", "2"]}
+		}`))
+		assert.Equal(t, ConfigError{
+			`dedup priority: unknown provider "2"`,
+			`unknown dedup key "id", expected "link" or "title"`,
+			`unknown dedup winner "oldest", expected "priority" or "latest_expiry"`,
+		}, err)
+	})
+	t.Run("persistence", func(t *testing.T) {
+		_, err := ParseAppConfig([]byte(`{
+			"listen": "127.0.0.1:8080",
+			"providers": {"1": {"client": "sample", "refresh_interval": "1m", "length": 10}},
+			"mix": [{"type": "1"}],
+			"persistence": {"interval": "0s"}
+		}`))
+		assert.Equal(t, ConfigError{
+			"persistence path is empty",
+			"persistence interval should be positive",
+		}, err)
+	})
+	t.Run("readiness", func(t *testing.T) {
+		_, err := ParseAppConfig([]byte(`{
+			"listen": "127.0.0.1:8080",
+			"providers": {"1": {"client": "sample", "refresh_interval": "1m", "length": 10}},
+			"mix": [{"type": "1"}],
+			"readiness": {"quorum": 2, "deadline": "-1s"}
+		}`))
+		assert.Equal(t, ConfigError{
+			"readiness quorum should not exceed the number of the providers",
+			"readiness deadline should not be negative",
+		}, err)
+	})
+	t.Run("pinned slots", func(t *testing.T) {
+		config, err := ParseAppConfig([]byte(`{
+			"listen": "127.0.0.1:8080",
+			"providers": {
+				"1": {"client": "sample", "refresh_interval": "1m", "length": 10},
+				"2": {"client": "sample", "refresh_interval": "1m", "length": 10}
+			},
+			"mix": [{"type": "1"}],
+			"pinned": [{"type": "2", "every": 10}, {"type": "2", "fallback": "1", "positions": [3, 15]}]
+		}`))
+		assert.NoError(t, err)
+		assert.Equal(t, []PinnedSlot{
+			{ContentConfig: ContentConfig{Type: Provider2}, Every: 10},
+			{ContentConfig: ContentConfig{Type: Provider2, Fallback: &Provider1}, Positions: []int{3, 15}},
+		}, config.Pinned)
+	})
+	t.Run("invalid pinned slots", func(t *testing.T) {
+		_, err := ParseAppConfig([]byte(`{
+			"listen": "127.0.0.1:8080",
+			"providers": {"1": {"client": "sample", "refresh_interval": "1m", "length": 10}},
+			"mix": [{"type": "1"}],
+			"pinned": [{"type": "2", "every": 10}, {"type": "1"}, {"type": "1", "every": -1, "positions": [-3]}]
+		}`))
+		assert.Equal(t, ConfigError{
+			`pinned slot 0: unknown provider "2"`,
+			"pinned slot 1: neither positions nor every is set",
+			"pinned slot 2: every should not be negative",
+			"pinned slot 2: position -3 should not be negative",
+		}, err)
+		_, err = ParseAppConfig([]byte(`{
+			"listen": "127.0.0.1:8080",
+			"providers": {"1": {"client": "sample", "refresh_interval": "1m", "length": 10}},
+			"mix": [{"type": "1"}],
+			"pinned": [{"type": "1", "positions": [1000000]}]
+		}`))
+		assert.Equal(t, ConfigError{"pinned slots: laying them over the mix takes more than 100000 slots"}, err)
+	})
+	t.Run("weighted strategy", func(t *testing.T) {
+		config, err := ParseAppConfig([]byte(`{
+			"listen": "127.0.0.1:8080",
+			"providers": {
+				"1": {"client": "sample", "refresh_interval": "1m", "length": 10},
+				"2": {"client": "sample", "refresh_interval": "1m", "length": 10}
+			},
+			"strategy": "weighted",
+			"weighted": {"seed": 7, "weights": [{"type": "1", "fallback": "2", "weight": 3}, {"type": "2", "weight": 1}]}
+		}`))
+		assert.NoError(t, err)
+		assert.Equal(t, &WeightedMix{
+			Seed: 7,
+			Weights: []WeightedConfig{
+				{ContentConfig: ContentConfig{Type: Provider1, Fallback: &Provider2}, Weight: 3},
+				{ContentConfig: ContentConfig{Type: Provider2}, Weight: 1},
+			},
+		}, config.Weighted)
+		assert.IsType(t, WeightedSequencer{}, config.sequencer())
+	})
+	t.Run("invalid weighted strategy", func(t *testing.T) {
+		_, err := ParseAppConfig([]byte(`{
+			"listen": "127.0.0.1:8080",
+			"providers": {"1": {"client": "sample", "refresh_interval": "1m", "length": 10}},
+			"strategy": "weighted",
+			"weighted": {"weights": [{"type": "1", "weight": 0}, {"type": "1", "fallback": "3", "weight": 20000}]}
+		}`))
+		assert.Equal(t, ConfigError{
+			"weighted mix 0: weight should be positive",
+			`weighted mix 1: unknown fallback provider "3"`,
+			"weighted mix: the sum of the weights should not exceed 10000",
+		}, err)
+		_, err = ParseAppConfig([]byte(`{
+			"listen": "127.0.0.1:8080",
+			"providers": {"1": {"client": "sample", "refresh_interval": "1m", "length": 10}},
+			"strategy": "random"
+		}`))
+		assert.Equal(t, ConfigError{`unknown strategy "random"`}, err)
+	})
+	t.Run("http json provider", func(t *testing.T) {
+		config, err := ParseAppConfig([]byte(`{
+			"listen": "127.0.0.1:8080",
+			"providers": {
+				"1": {"client": "http_json", "endpoint": "https://example.com/news", "refresh_interval": "1m", "length": 10,
+					"http": {"user_ip_header": "X-Forwarded-For", "mapping": {"title": "headline", "link": "url"}, "timeout": "2s"}}
+			},
+			"mix": [{"type": "1"}]
+		}`))
+		assert.NoError(t, err)
+		providerConfigs, err := config.ProviderConfigs()
+		assert.NoError(t, err)
+		assert.IsType(t, &HTTPJSONClient{}, providerConfigs[Provider1].client)
+	})
+	t.Run("feed provider", func(t *testing.T) {
+		config, err := ParseAppConfig([]byte(`{
+			"listen": "127.0.0.1:8080",
+			"providers": {
+				"1": {"client": "feed", "endpoint": "https://example.com/rss", "refresh_interval": "1m", "length": 10,
+					"feed": {"item_ttl": "12h"}}
+			},
+			"mix": [{"type": "1"}]
+		}`))
+		assert.NoError(t, err)
+		providerConfigs, err := config.ProviderConfigs()
+		assert.NoError(t, err)
+		assert.IsType(t, &FeedClient{}, providerConfigs[Provider1].client)
+	})
+	t.Run("resilience", func(t *testing.T) {
+		config, err := ParseAppConfig([]byte(`{
+			"listen": "127.0.0.1:8080",
+			"providers": {
+				"1": {"client": "sample", "refresh_interval": "1m", "length": 10, "resilience": {
+					"retries": {"initial_delay": "100ms", "max_attempts": 2},
+					"timeout": "2s",
+					"breaker": {"failure_threshold": 3, "open_timeout": "1m"},
+					"rate_limit": {"rate": 0.5, "burst": 2}
+				}}
+			},
+			"mix": [{"type": "1"}]
+		}`))
+		assert.NoError(t, err)
+		providerConfigs, err := config.ProviderConfigs()
+		assert.NoError(t, err)
+		assert.Len(t, providerConfigs[Provider1].middleware, 4)
+		assert.NotNil(t, providerConfigs[Provider1].breaker)
+	})
+	t.Run("invalid resilience", func(t *testing.T) {
+		_, err := ParseAppConfig([]byte(`{
+			"listen": "127.0.0.1:8080",
+			"providers": {
+				"1": {"client": "sample", "refresh_interval": "1m", "length": 10, "resilience": {
+					"retries": {"initial_delay": "100ms"},
+					"timeout": "-2s",
+					"breaker": {"failure_threshold": 0, "open_timeout": "1m"},
+					"rate_limit": {"rate": 0}
+				}}
+			},
+			"mix": [{"type": "1"}]
+		}`))
+		assert.Equal(t, ConfigError{
+			`provider "1": retries max_attempts should be positive`,
+			`provider "1": resilience timeout should not be negative`,
+			`provider "1": breaker failure_threshold should be positive`,
+			`provider "1": rate_limit rate should be positive`,
+		}, err)
+	})
+	t.Run("min fresh share", func(t *testing.T) {
+		_, err := ParseAppConfig([]byte(`{
+			"listen": "127.0.0.1:8080",
+			"providers": {"1": {"client": "sample", "refresh_interval": "1m", "length": 10, "min_fresh_share": 1.5}},
+			"mix": [{"type": "1"}]
+		}`))
+		assert.Equal(t, ConfigError{`provider "1": min_fresh_share should be between 0 and 1`}, err)
+	})
+	t.Run("invalid http json provider", func(t *testing.T) {
+		_, err := ParseAppConfig([]byte(`{
+			"listen": "127.0.0.1:8080",
+			"providers": {
+				"1": {"client": "http_json", "refresh_interval": "1m", "length": 10},
+				"2": {"client": "http_json", "endpoint": "example.com", "refresh_interval": "1m", "length": 10,
+					"http": {"mapping": {"headline": "title"}, "timeout": "-1s"}}
+			},
+			"mix": [{"type": "1"}]
+		}`))
+		assert.Equal(t, ConfigError{
+			`provider "1": endpoint is empty`,
+			`provider "2": endpoint "example.com" is not a valid http(s) URL`,
+			`provider "2": unknown mapped field "headline", expected one of id, title, summary, link, expiry`,
+			`provider "2": http timeout should not be negative`,
+		}, err)
+	})
+	t.Run("empty config", func(t *testing.T) {
+		_, err := ParseAppConfig([]byte(`{}`))
+		assert.Equal(t, ConfigError{
+			"listen address is empty",
+			"no providers configured",
+			"content mix is empty",
+		}, err)
+	})
+}
+
+func TestLoadAppConfig(t *testing.T) {
+	t.Run("config from file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.json")
+		assert.NoError(t, os.WriteFile(path, []byte(validConfigJSON), 0600))
+		config, err := LoadAppConfig(path)
+		assert.NoError(t, err)
+		assert.Equal(t, "0.0.0.0:9090", config.Listen)
+	})
+	t.Run("missing file", func(t *testing.T) {
+		_, err := LoadAppConfig(filepath.Join(t.TempDir(), "config.json"))
+		assert.Error(t, err)
+	})
+	t.Run("example config is valid", func(t *testing.T) {
+		_, err := LoadAppConfig("config.example.json")
+		assert.NoError(t, err)
+	})
+}
+
+func TestDefaultAppConfig(t *testing.T) {
+	config := DefaultAppConfig()
+	assert.NoError(t, config.Validate())
+	bb, err := json.Marshal(config)
+	assert.NoError(t, err)
+	parsed, err := ParseAppConfig(bb)
+	assert.NoError(t, err)
+	assert.Equal(t, config, parsed)
+}
@@ -1,51 +1,362 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
 	"sync"
 	"time"
 )
 
+// evictionGranularity batches the evictions of the items expiring close to each other: the items expiring within
+// the same interval of it are evicted together at its start, so the items of the distinct expiries do not replace
+// the state, pushing out the snapshots, one by one. The items are evicted early rather than served after they expire.
+const evictionGranularity = time.Minute
+
+// defaultLoadWait bounds the wait of SetProvider for the first refresh of the provider.
+const defaultLoadWait = time.Second * 30
+
 // TimeExpirationCacher the component to cache the data from the providers locally and refresh it on the time basis.
 type TimeExpirationCacher struct {
 	providerConfigs map[Provider]ProviderConfig
+	runners         map[Provider]*providerRunner
+	runnersLock     sync.Mutex
 	lastUpdate      map[Provider]time.Time
 	state           *inMemoryState
 	stateLock       sync.RWMutex
-	stopc           chan struct{}
-	finishWG        sync.WaitGroup
+	clock           Clock
+	// snapshots are the replaced states kept for the listings started with them, the oldest first.
+	snapshots     []snapshot
+	keepSnapshots int
+	snapshotGrace time.Duration
+	// fetched is the content as the providers returned it, the state content is built from it.
+	fetched      map[Provider][]*ContentItem
+	deduplicator *Deduplicator
+	// persistPath is the snapshot file the state is saved to every persistInterval, the state is not saved if empty.
+	persistPath       string
+	persistInterval   time.Duration
+	persister         *providerRunner
+	readiness         *readinessTracker
+	readinessDeadline time.Duration
+	deadliner         *providerRunner
+	metrics           *Metrics
+	// loadWait is how long SetProvider waits for the first refresh of the provider.
+	loadWait time.Duration
+}
+
+// snapshot is the replaced state and the time it was replaced at.
+type snapshot struct {
+	state      *inMemoryState
+	replacedAt time.Time
+}
+
+// providerRunner is the routine refreshing the content of one provider.
+type providerRunner struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// stop cancels the fetch in flight and waits for the routine to finish.
+func (pr *providerRunner) stop() {
+	pr.cancel()
+	<-pr.done
+}
+
+// CacherOption customises the TimeExpirationCacher on construction.
+type CacherOption func(*TimeExpirationCacher)
+
+// WithClock sets the clock the TimeExpirationCacher uses to schedule the refreshes.
+func WithClock(clock Clock) CacherOption {
+	return func(tec *TimeExpirationCacher) {
+		tec.clock = clock
+	}
+}
+
+// WithSnapshots makes the TimeExpirationCacher keep up to keep replaced states for the grace period after the replacement,
+// so the listing started with a state can go on with it after the refresh.
+func WithSnapshots(keep int, grace time.Duration) CacherOption {
+	return func(tec *TimeExpirationCacher) {
+		tec.keepSnapshots = keep
+		tec.snapshotGrace = grace
+	}
+}
+
+// WithDeduplicator makes the TimeExpirationCacher drop the duplicates from the content of the state every time it is built.
+func WithDeduplicator(deduplicator *Deduplicator) CacherOption {
+	return func(tec *TimeExpirationCacher) {
+		tec.deduplicator = deduplicator
+	}
+}
+
+// WithMetrics makes the TimeExpirationCacher record the duration and the outcome of the refreshes,
+// and the number of the items cached for every provider.
+func WithMetrics(metrics *Metrics) CacherOption {
+	return func(tec *TimeExpirationCacher) {
+		tec.metrics = metrics
+	}
 }
 
 // NewTimeExpirationCacher the constructor of the TimeExpirationCacher
-func NewTimeExpirationCacher(providerConfigs map[Provider]ProviderConfig) *TimeExpirationCacher {
-	// todo validation here
+func NewTimeExpirationCacher(providerConfigs map[Provider]ProviderConfig, opts ...CacherOption) (*TimeExpirationCacher, error) {
+	var problems ConfigError
+	for p, pc := range providerConfigs {
+		problems = append(problems, pc.validate(p)...)
+	}
+	if len(problems) != 0 {
+		sort.Strings(problems)
+		return nil, problems
+	}
 	cacher := &TimeExpirationCacher{
-		providerConfigs: providerConfigs,
-		state: &inMemoryState{
-			content: make(map[Provider][]*ContentItem, len(providerConfigs)),
-			fails:   make(map[Provider]bool, len(providerConfigs)),
-		},
-		lastUpdate: make(map[Provider]time.Time, len(providerConfigs)),
-		stopc:      make(chan struct{}),
+		providerConfigs: make(map[Provider]ProviderConfig, len(providerConfigs)),
+		runners:         make(map[Provider]*providerRunner, len(providerConfigs)),
+		lastUpdate:      make(map[Provider]time.Time, len(providerConfigs)),
+		fetched:         make(map[Provider][]*ContentItem, len(providerConfigs)),
+		clock:           realClock{},
+		readiness:       newReadinessTracker(0),
+		loadWait:        defaultLoadWait,
 	}
-	return cacher
+	for p, pc := range providerConfigs {
+		cacher.providerConfigs[p] = pc
+	}
+	for _, opt := range opts {
+		opt(cacher)
+	}
+	cacher.state = &inMemoryState{
+		content:    make(map[Provider][]*ContentItem, len(providerConfigs)),
+		fails:      make(map[Provider]bool, len(providerConfigs)),
+		statuses:   make(map[Provider]ProviderStatus, len(providerConfigs)),
+		staleUntil: make(map[Provider]time.Time, len(providerConfigs)),
+		breakers:   make(map[Provider]*CircuitBreaker, len(providerConfigs)),
+		clock:      cacher.clock,
+		// the versions start from the time, so the cursors made before a restart do not match the new states
+		version: uint64(cacher.clock.Now().UnixNano()),
+	}
+	for p, pc := range providerConfigs {
+		if pc.breaker != nil {
+			cacher.state.breakers[p] = pc.breaker
+		}
+	}
+	return cacher, nil
 }
 
-// ProviderConfig the configuration for the provider for the TimeExpirationCacher
+// ProviderConfig the configuration for the provider for the TimeExpirationCacher.
+// The provider is refreshed every expiration plus a random delay up to jitter,
+// so the providers with the same expiration do not hit the upstream at once.
+// A failing provider is retried according to the retry policy instead,
+// and keeps serving its last good content while it is not older than maxStaleness.
+// A fetch taking longer than fetchTimeout (not limited if 0) is cancelled and counts as failed.
+// The items are dropped from the state as they expire, the provider is refreshed early
+// when the share of its items not expired yet falls below minFreshShare.
+// The client is called through the middleware, the provider is reported failing while the breaker is open.
 type ProviderConfig struct {
-	expiration time.Duration
-	length     int
-	userIp     string
-	client     Client
+	expiration    time.Duration
+	jitter        time.Duration
+	retry         RetryPolicy
+	maxStaleness  time.Duration
+	fetchTimeout  time.Duration
+	minFreshShare float64
+	length        int
+	userIp        string
+	client        Client
+	middleware    []ClientMiddleware
+	breaker       *CircuitBreaker
+}
+
+func (pc ProviderConfig) validate(p Provider) (problems []string) {
+	report := func(problem string) {
+		problems = append(problems, fmt.Sprintf("provider %q: %s", p, problem))
+	}
+	if pc.expiration <= 0 {
+		report("expiration should be positive")
+	}
+	if pc.jitter < 0 {
+		report("jitter should not be negative")
+	}
+	if pc.maxStaleness < 0 {
+		report("max staleness should not be negative")
+	}
+	if pc.fetchTimeout < 0 {
+		report("fetch timeout should not be negative")
+	}
+	if pc.minFreshShare < 0 || pc.minFreshShare > 1 {
+		report("min fresh share should be between 0 and 1")
+	}
+	if pc.length <= 0 {
+		report("length should be positive")
+	}
+	if pc.client == nil {
+		report("client is not set")
+	}
+	return
+}
+
+// RetryPolicy defines how a failing provider is retried: the first retry happens after InitialDelay,
+// every next one is Multiplier times later, but not later than MaxDelay.
+// After MaxAttempts retries (unlimited if 0) the provider is back on its normal cadence.
+// The zero policy disables the retries.
+type RetryPolicy struct {
+	InitialDelay time.Duration
+	Multiplier   float64
+	MaxDelay     time.Duration
+	MaxAttempts  int
+}
+
+// backoff returns the delay before the retry following the given number of consecutive failures,
+// and false if the provider should not be retried.
+func (rp RetryPolicy) backoff(failures int) (time.Duration, bool) {
+	if rp.InitialDelay <= 0 || failures <= 0 || (rp.MaxAttempts > 0 && failures > rp.MaxAttempts) {
+		return 0, false
+	}
+	multiplier := rp.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := float64(rp.InitialDelay) * math.Pow(multiplier, float64(failures-1))
+	if rp.MaxDelay > 0 && delay > float64(rp.MaxDelay) {
+		return rp.MaxDelay, true
+	}
+	if delay >= math.MaxInt64 {
+		return math.MaxInt64, true
+	}
+	return time.Duration(delay), true
+}
+
+// ProviderStatus describes the refresh state of a provider.
+type ProviderStatus struct {
+	// Failures is the number of consecutive failed refreshes.
+	Failures int
+	// Retrying is true when the next refresh is a retry on the retry policy instead of the normal cadence.
+	Retrying bool
+	// NextAttempt is the time of the next scheduled refresh.
+	NextAttempt time.Time
+	// LastError is the error of the last failed refresh.
+	LastError string
+	// LastSuccess is the time of the last successful refresh.
+	LastSuccess time.Time
 }
 
+// ProviderHealth is the health of a provider as seen by the consumers of the state.
+type ProviderHealth string
+
+const (
+	// HealthOK the last refresh of the provider succeeded.
+	HealthOK ProviderHealth = "ok"
+	// HealthDegraded the provider fails, but still serves its last good content within the staleness budget.
+	HealthDegraded ProviderHealth = "degraded"
+	// HealthFailed the provider fails and has no content young enough to serve.
+	HealthFailed ProviderHealth = "failed"
+)
+
 type inMemoryState struct {
-	content map[Provider][]*ContentItem
-	fails   map[Provider]bool
+	content  map[Provider][]*ContentItem
+	fails    map[Provider]bool
+	statuses map[Provider]ProviderStatus
+	// staleUntil keeps the end of the staleness budget for the failing providers serving their last good content.
+	staleUntil map[Provider]time.Time
+	// breakers are shared with the provider clients, so their state is always the current one.
+	breakers map[Provider]*CircuitBreaker
+	// failing are the providers reported failing, frozen when the state is set,
+	// so every page of the state is sequenced with the same providers failing.
+	failing map[Provider]bool
+	clock   Clock
+	// version identifies the state, every next state has the next version.
+	version uint64
 }
 
-// Fails returns if a given provider fails to be load.
+// Version returns the version of the state.
+func (ims *inMemoryState) Version() uint64 {
+	return ims.version
+}
+
+// Fails returns if a given provider fails to be load, as it did when the state was set.
 func (ims *inMemoryState) Fails(p Provider) bool {
-	return ims.fails[p]
+	return ims.failing[p]
+}
+
+// failsNow returns if a given provider fails to be load at the moment.
+// The provider serving its last good content within the staleness budget is not considered failing,
+// unless its circuit breaker is open.
+func (ims *inMemoryState) failsNow(p Provider) bool {
+	return (ims.fails[p] && !ims.servesStale(p)) || ims.breakerState(p) == BreakerOpen
+}
+
+// freeze sets the providers failing at the moment as the failing ones of the state.
+func (ims *inMemoryState) freeze() {
+	ims.failing = make(map[Provider]bool, len(ims.fails))
+	for p := range ims.fails {
+		if ims.failsNow(p) {
+			ims.failing[p] = true
+		}
+	}
+	for p := range ims.breakers {
+		if ims.failsNow(p) {
+			ims.failing[p] = true
+		}
+	}
+}
+
+// outdated returns if the providers failing at the moment are not the ones of the state anymore,
+// as the staleness budget of a provider has run out or its circuit breaker has changed the state.
+func (ims *inMemoryState) outdated() bool {
+	for p := range ims.fails {
+		if ims.failsNow(p) != ims.failing[p] {
+			return true
+		}
+	}
+	for p := range ims.breakers {
+		if ims.failsNow(p) != ims.failing[p] {
+			return true
+		}
+	}
+	return false
+}
+
+// Available returns the number of the items cached for a given provider.
+func (ims *inMemoryState) Available(p Provider) int {
+	return len(ims.content[p])
+}
+
+// Health returns the health of a given provider.
+func (ims *inMemoryState) Health(p Provider) ProviderHealth {
+	switch {
+	case ims.breakerState(p) == BreakerOpen:
+		return HealthFailed
+	case !ims.fails[p]:
+		return HealthOK
+	case ims.servesStale(p):
+		return HealthDegraded
+	default:
+		return HealthFailed
+	}
+}
+
+// breakerState returns the state of the provider circuit breaker, closed if it has no breaker.
+func (ims *inMemoryState) breakerState(p Provider) BreakerState {
+	breaker, ok := ims.breakers[p]
+	if !ok {
+		return BreakerClosed
+	}
+	return breaker.State()
+}
+
+func (ims *inMemoryState) servesStale(p Provider) bool {
+	until, ok := ims.staleUntil[p]
+	return ok && ims.now().Before(until)
+}
+
+func (ims *inMemoryState) now() time.Time {
+	if ims.clock == nil {
+		return time.Now()
+	}
+	return ims.clock.Now()
+}
+
+// ProviderStatus returns the refresh state of a given provider.
+func (ims *inMemoryState) ProviderStatus(p Provider) ProviderStatus {
+	return ims.statuses[p]
 }
 
 // ContentItem returns the content item for a given provider and index.
@@ -62,12 +373,26 @@ func (ims *inMemoryState) copy() *inMemoryState {
 		return nil
 	}
 	c := &inMemoryState{
-		content: make(map[Provider][]*ContentItem, len(ims.content)),
-		fails:   make(map[Provider]bool, len(ims.fails)),
+		content:    make(map[Provider][]*ContentItem, len(ims.content)),
+		fails:      make(map[Provider]bool, len(ims.fails)),
+		statuses:   make(map[Provider]ProviderStatus, len(ims.statuses)),
+		staleUntil: make(map[Provider]time.Time, len(ims.staleUntil)),
+		breakers:   make(map[Provider]*CircuitBreaker, len(ims.breakers)),
+		clock:      ims.clock,
+		version:    ims.version + 1,
 	}
 	for k, v := range ims.fails {
 		c.fails[k] = v
 	}
+	for k, v := range ims.statuses {
+		c.statuses[k] = v
+	}
+	for k, v := range ims.staleUntil {
+		c.staleUntil[k] = v
+	}
+	for k, v := range ims.breakers {
+		c.breakers[k] = v
+	}
 	for k, v := range ims.content {
 		c.content[k] = copyContentItems(v)
 	}
@@ -83,59 +408,406 @@ func copyContentItems(contentItems []*ContentItem) []*ContentItem {
 }
 
 // GetState returns the state with the content items saved locally and the information if a provider fails.
+// The state is replaced with the next version if the providers failing at the moment are not its failing ones anymore.
 func (tec *TimeExpirationCacher) GetState() State {
 	tec.stateLock.RLock()
 	state := tec.state
 	tec.stateLock.RUnlock()
-	return state
+	if !state.outdated() {
+		return state
+	}
+	tec.stateLock.Lock()
+	defer tec.stateLock.Unlock()
+	if tec.state.outdated() {
+		tec.setState(tec.state.copy())
+	}
+	return tec.state
 }
 
-// Start starts the component, i.e. the routines to refresh the cache.
+// GetSnapshot returns the state of the given version, if it is the current one or a snapshot kept for the grace period.
+func (tec *TimeExpirationCacher) GetSnapshot(version uint64) (State, bool) {
+	tec.stateLock.RLock()
+	defer tec.stateLock.RUnlock()
+	if tec.state.version == version {
+		return tec.state, true
+	}
+	now := tec.clock.Now()
+	for _, s := range tec.snapshots {
+		if s.state.version == version && now.Sub(s.replacedAt) <= tec.snapshotGrace {
+			return s.state, true
+		}
+	}
+	return nil, false
+}
+
+// setState replaces the state keeping the replaced one as the snapshot, and drops the snapshots beyond the limits.
+// The failing providers of the new state are frozen. The state lock should be held.
+func (tec *TimeExpirationCacher) setState(state *inMemoryState) {
+	state.freeze()
+	now := tec.clock.Now()
+	if tec.keepSnapshots > 0 {
+		tec.snapshots = append(tec.snapshots, snapshot{state: tec.state, replacedAt: now})
+	}
+	first := 0
+	if len(tec.snapshots) > tec.keepSnapshots {
+		first = len(tec.snapshots) - tec.keepSnapshots
+	}
+	for first < len(tec.snapshots) && now.Sub(tec.snapshots[first].replacedAt) > tec.snapshotGrace {
+		first++
+	}
+	tec.snapshots = append([]snapshot(nil), tec.snapshots[first:]...)
+	tec.state = state
+}
+
+// Start starts the component, i.e. the routines to refresh the cache. It returns right away,
+// the component becomes ready when the providers have been loaded for the first time, see Ready.
+// The providers restored from the snapshot file serve the restored content while they are refreshed.
 func (tec *TimeExpirationCacher) Start() {
-	firstTimeWG := &sync.WaitGroup{}
-	firstTimeWG.Add(len(tec.providerConfigs))
-	tec.finishWG.Add(len(tec.providerConfigs))
-	for provider, providerConfig := range tec.providerConfigs {
-		p := provider
-		pc := providerConfig
-		go func() {
-			tec.updateProvider(p, pc)
-			firstTimeWG.Done()
-			timer := time.NewTimer(pc.expiration)
-			for {
-				select {
-				case <-timer.C:
-					tec.updateProvider(p, pc)
-				case <-tec.stopc:
-					tec.finishWG.Done()
-					return
-				}
+	tec.runnersLock.Lock()
+	defer tec.runnersLock.Unlock()
+	providers := make([]Provider, 0, len(tec.providerConfigs))
+	for provider := range tec.providerConfigs {
+		providers = append(providers, provider)
+	}
+	tec.readiness.track(providers...)
+	if tec.persistPath != "" {
+		restored, err := tec.restore()
+		if err != nil {
+			log.Printf("cannot restore the cache, starting cold: %v", err)
+		}
+		for provider, ok := range restored {
+			if ok {
+				tec.readiness.restored(provider)
 			}
-		}()
+		}
+	}
+	for provider, providerConfig := range tec.providerConfigs {
+		runner, _ := tec.startProvider(provider, providerConfig)
+		tec.runners[provider] = runner
+	}
+	if tec.persistPath != "" {
+		tec.persister = tec.startPersisting()
+	}
+	if tec.readinessDeadline > 0 {
+		tec.deadliner = tec.startDeadline()
 	}
-	firstTimeWG.Wait()
 }
 
-// Stop stops the component, i.e. the routines to refresh the cache.
+// Stop stops the component, i.e. the routines to refresh the cache, and saves the state to the snapshot file.
 func (tec *TimeExpirationCacher) Stop() {
-	close(tec.stopc)
-	tec.finishWG.Wait()
+	tec.runnersLock.Lock()
+	defer tec.runnersLock.Unlock()
+	for provider, runner := range tec.runners {
+		runner.stop()
+		delete(tec.runners, provider)
+	}
+	if tec.persister != nil {
+		tec.persister.stop()
+		tec.persister = nil
+	}
+	if tec.deadliner != nil {
+		tec.deadliner.stop()
+		tec.deadliner = nil
+	}
 }
 
-func (tec *TimeExpirationCacher) updateProvider(provider Provider, providerConfig ProviderConfig) {
-	client := providerConfig.client
-	if client != nil {
-		content, err := client.GetContent(providerConfig.userIp, providerConfig.length)
+// SetProvider adds the provider to the running component or replaces its configuration, restarting its refresh routine.
+// The content already cached for the provider is kept until the first refresh with the new configuration,
+// which the call waits for up to the load wait, the refresh goes on in the background after it.
+func (tec *TimeExpirationCacher) SetProvider(provider Provider, providerConfig ProviderConfig) error {
+	return tec.SetProviders(map[Provider]ProviderConfig{provider: providerConfig})
+}
+
+// SetProviders sets the providers the same way as SetProvider. All of them are restarted first,
+// then the call waits for their first refreshes up to the load wait once for all of them.
+// None of the providers is set if the configuration of one is not valid.
+func (tec *TimeExpirationCacher) SetProviders(providerConfigs map[Provider]ProviderConfig) error {
+	var problems ConfigError
+	for p, pc := range providerConfigs {
+		problems = append(problems, pc.validate(p)...)
+	}
+	if len(problems) != 0 {
+		sort.Strings(problems)
+		return problems
+	}
+	tec.runnersLock.Lock()
+	loading := make(map[Provider]<-chan struct{}, len(providerConfigs))
+	for provider, providerConfig := range providerConfigs {
+		if runner, ok := tec.runners[provider]; ok {
+			runner.stop()
+		}
 		tec.stateLock.Lock()
 		newState := tec.state.copy()
-		if err != nil {
-			newState.fails[provider] = true
+		if providerConfig.breaker != nil {
+			newState.breakers[provider] = providerConfig.breaker
 		} else {
-			newState.fails[provider] = false
-			newState.content[provider] = content
+			delete(newState.breakers, provider)
 		}
-		tec.state = newState
-		tec.lastUpdate[provider] = time.Now()
+		tec.setState(newState)
 		tec.stateLock.Unlock()
+		runner, loaded := tec.startProvider(provider, providerConfig)
+		tec.runners[provider] = runner
+		tec.providerConfigs[provider] = providerConfig
+		loading[provider] = loaded
+	}
+	tec.runnersLock.Unlock()
+	timer := tec.clock.NewTimer(tec.loadWait)
+	defer timer.Stop()
+	for provider, loaded := range loading {
+		select {
+		case <-loaded:
+			delete(loading, provider)
+		case <-timer.C():
+			for provider, loaded := range loading {
+				select {
+				case <-loaded:
+				default:
+					log.Printf("provider %q is still loading after %s, it goes on in the background", provider, tec.loadWait)
+				}
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// RemoveProvider stops refreshing the provider and drops its content from the cache.
+func (tec *TimeExpirationCacher) RemoveProvider(provider Provider) {
+	tec.runnersLock.Lock()
+	if runner, ok := tec.runners[provider]; ok {
+		runner.stop()
+		delete(tec.runners, provider)
+	}
+	delete(tec.providerConfigs, provider)
+	tec.runnersLock.Unlock()
+
+	tec.stateLock.Lock()
+	defer tec.stateLock.Unlock()
+	newState := tec.state.copy()
+	delete(newState.content, provider)
+	delete(newState.fails, provider)
+	delete(newState.statuses, provider)
+	delete(newState.staleUntil, provider)
+	delete(newState.breakers, provider)
+	delete(tec.fetched, provider)
+	tec.metrics.deleteItems(provider)
+	tec.buildContent(newState)
+	tec.setState(newState)
+	delete(tec.lastUpdate, provider)
+	tec.readiness.remove(provider)
+}
+
+// startProvider starts the refresh routine of the provider,
+// the returned channel is closed when the provider has been loaded for the first time.
+func (tec *TimeExpirationCacher) startProvider(provider Provider, providerConfig ProviderConfig) (*providerRunner, <-chan struct{}) {
+	providerConfig.client = Chain(providerConfig.client, providerConfig.middleware...)
+	tec.readiness.track(provider)
+	ctx, cancel := context.WithCancel(context.Background())
+	runner := &providerRunner{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	loaded := make(chan struct{})
+	go func() {
+		defer close(runner.done)
+		delay := tec.updateProvider(ctx, provider, providerConfig)
+		close(loaded)
+		tec.refreshLoop(ctx, provider, providerConfig, delay)
+	}()
+	return runner, loaded
+}
+
+// refreshLoop refreshes the provider after the given delay, and then after the delays returned by every refresh,
+// until the component is stopped. When an item of the provider expires before the refresh, the loop wakes up to evict it,
+// and refreshes the provider right away if too few of its items are left.
+func (tec *TimeExpirationCacher) refreshLoop(ctx context.Context, provider Provider, providerConfig ProviderConfig,
+	delay time.Duration) {
+	refreshAt := tec.clock.Now().Add(delay)
+	for ctx.Err() == nil {
+		now := tec.clock.Now()
+		wait := refreshAt.Sub(now)
+		evictAt, expiring := tec.nextEviction(provider, now)
+		evict := expiring && evictAt.Before(refreshAt)
+		if evict {
+			wait = evictAt.Sub(now)
+		}
+		timer := tec.clock.NewTimer(wait)
+		select {
+		case <-timer.C():
+			if evict && !tec.evictExpired(provider, providerConfig) {
+				continue
+			}
+			delay = tec.updateProvider(ctx, provider, providerConfig)
+			refreshAt = tec.clock.Now().Add(delay)
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// nextEviction returns when the next of the items of the provider not evicted at the given time is evicted,
+// the start of the interval of evictionGranularity it expires in, and false if none of them is going to expire.
+func (tec *TimeExpirationCacher) nextEviction(provider Provider, now time.Time) (time.Time, bool) {
+	tec.stateLock.RLock()
+	defer tec.stateLock.RUnlock()
+	cutoff := evictionCutoff(now)
+	var next time.Time
+	for _, item := range tec.fetched[provider] {
+		if item != nil && !item.Expiry.Before(cutoff) && (next.IsZero() || item.Expiry.Before(next)) {
+			next = item.Expiry
+		}
+	}
+	return next.Truncate(evictionGranularity), !next.IsZero()
+}
+
+// evictionCutoff returns the end of the interval of evictionGranularity the time is in,
+// the items expiring before it are evicted at the time.
+func evictionCutoff(now time.Time) time.Time {
+	return now.Truncate(evictionGranularity).Add(evictionGranularity)
+}
+
+// evictExpired rebuilds the state without the expired items, the state is not replaced if none has expired,
+// e.g. they have been evicted by another provider waking up at the same time.
+// It returns true if the share of the items of the provider not expired yet has fallen below the minimum.
+func (tec *TimeExpirationCacher) evictExpired(provider Provider, providerConfig ProviderConfig) bool {
+	tec.stateLock.Lock()
+	defer tec.stateLock.Unlock()
+	newState := tec.state.copy()
+	tec.buildContent(newState)
+	if !sameContent(newState.content, tec.state.content) {
+		tec.setState(newState)
+	}
+	fetched := tec.fetched[provider]
+	if len(fetched) == 0 {
+		return false
+	}
+	fresh := len(freshItems(fetched, tec.clock.Now()))
+	return float64(fresh) < providerConfig.minFreshShare*float64(len(fetched))
+}
+
+// nextDelay returns the delay before the next refresh of the provider after the given number of consecutive failures,
+// and if the refresh is a retry on the retry policy.
+func nextDelay(providerConfig ProviderConfig, failures int) (time.Duration, bool) {
+	if delay, ok := providerConfig.retry.backoff(failures); ok && delay < providerConfig.expiration {
+		return delay, true
+	}
+	return nextRefreshDelay(providerConfig), false
+}
+
+func nextRefreshDelay(providerConfig ProviderConfig) time.Duration {
+	delay := providerConfig.expiration
+	if providerConfig.jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(providerConfig.jitter)))
+	}
+	return delay
+}
+
+// updateProvider refreshes the content of the provider and returns the delay before the next refresh.
+// The fetch is limited by the fetch timeout and is cancelled with the context, the state is not updated then.
+func (tec *TimeExpirationCacher) updateProvider(ctx context.Context, provider Provider,
+	providerConfig ProviderConfig) time.Duration {
+	fetchCtx := ctx
+	if providerConfig.fetchTimeout > 0 {
+		var cancel context.CancelFunc
+		fetchCtx, cancel = context.WithTimeout(ctx, providerConfig.fetchTimeout)
+		defer cancel()
+	}
+	started := tec.clock.Now()
+	content, err := AsContextClient(providerConfig.client).
+		GetContentContext(fetchCtx, providerConfig.userIp, providerConfig.length)
+	if ctx.Err() != nil {
+		return 0
+	}
+	tec.metrics.observeUpdate(provider, tec.clock.Now().Sub(started), err)
+	tec.stateLock.Lock()
+	defer tec.stateLock.Unlock()
+	now := tec.clock.Now()
+	newState := tec.state.copy()
+	status := newState.statuses[provider]
+	if err != nil {
+		newState.fails[provider] = true
+		status.Failures++
+		status.LastError = err.Error()
+		if providerConfig.maxStaleness > 0 && len(tec.fetched[provider]) > 0 {
+			newState.staleUntil[provider] = status.LastSuccess.Add(providerConfig.maxStaleness)
+		} else {
+			delete(newState.staleUntil, provider)
+		}
+	} else {
+		newState.fails[provider] = false
+		tec.fetched[provider] = content
+		delete(newState.staleUntil, provider)
+		status.Failures = 0
+		status.LastError = ""
+		status.LastSuccess = now
+	}
+	delay, retrying := nextDelay(providerConfig, status.Failures)
+	status.Retrying = retrying
+	status.NextAttempt = now.Add(delay)
+	newState.statuses[provider] = status
+	tec.buildContent(newState)
+	tec.setState(newState)
+	tec.lastUpdate[provider] = now
+	tec.readiness.refreshed(provider, err == nil)
+	return delay
+}
+
+// buildContent sets the content of the state from the fetched one without the expired items.
+// With the deduplicator the duplicates are dropped,
+// the failing providers keep their content as it is, so they do not take the items from the serving ones.
+// Every provider keeps its items in order, so the indexes of the items in the state are contiguous.
+// The state lock should be held.
+func (tec *TimeExpirationCacher) buildContent(state *inMemoryState) {
+	now := tec.clock.Now()
+	state.content = make(map[Provider][]*ContentItem, len(tec.fetched))
+	serving := make(map[Provider][]*ContentItem, len(tec.fetched))
+	for p, content := range tec.fetched {
+		content = freshItems(content, now)
+		if tec.deduplicator == nil || state.failsNow(p) {
+			state.content[p] = content
+		} else {
+			serving[p] = content
+		}
+	}
+	if tec.deduplicator != nil {
+		for p, content := range tec.deduplicator.Deduplicate(serving) {
+			state.content[p] = content
+		}
+	}
+	for p, content := range state.content {
+		tec.metrics.setItems(p, len(content))
+	}
+}
+
+// sameContent returns if the providers have the same items in the same order.
+func sameContent(a, b map[Provider][]*ContentItem) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for p, items := range a {
+		other, ok := b[p]
+		if !ok || len(items) != len(other) {
+			return false
+		}
+		for i := range items {
+			if items[i] != other[i] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// freshItems returns the items which are not evicted at the given time, the ones expiring after the eviction cutoff,
+// the items with the zero expiry never expire.
+func freshItems(content []*ContentItem, now time.Time) []*ContentItem {
+	cutoff := evictionCutoff(now)
+	fresh := make([]*ContentItem, 0, len(content))
+	for _, item := range content {
+		if item == nil || item.Expiry.IsZero() || !item.Expiry.Before(cutoff) {
+			fresh = append(fresh, item)
+		}
 	}
+	return fresh
 }
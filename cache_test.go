@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -16,9 +18,77 @@ func (cp failedContentProvider) GetContent(userIP string, count int) ([]*Content
 	return resp, errors.New("network error")
 }
 
+// flakyContentProvider fails the first failures calls.
+type flakyContentProvider struct {
+	failures int32
+	calls    int32
+}
+
+func (cp *flakyContentProvider) GetContent(userIP string, count int) ([]*ContentItem, error) {
+	if atomic.AddInt32(&cp.calls, 1) <= cp.failures {
+		return nil, errors.New("network error")
+	}
+	return SampleContentProvider{Provider1}.GetContent(userIP, count)
+}
+
+// switchableContentProvider fails while the fail flag is set, it returns the content if set and the sample otherwise.
+type switchableContentProvider struct {
+	fail    int32
+	content staticContentProvider
+}
+
+func (cp *switchableContentProvider) setFail(fail bool) {
+	var v int32
+	if fail {
+		v = 1
+	}
+	atomic.StoreInt32(&cp.fail, v)
+}
+
+func (cp *switchableContentProvider) GetContent(userIP string, count int) ([]*ContentItem, error) {
+	if atomic.LoadInt32(&cp.fail) == 1 {
+		return nil, errors.New("network error")
+	}
+	if cp.content != nil {
+		return cp.content.GetContent(userIP, count)
+	}
+	return SampleContentProvider{Provider1}.GetContent(userIP, count)
+}
+
+func newTestCacher(t *testing.T, providerConfigs map[Provider]ProviderConfig, opts ...CacherOption) *TimeExpirationCacher {
+	cacher, err := NewTimeExpirationCacher(providerConfigs, opts...)
+	if err != nil {
+		t.Fatalf("couldn't create the cacher: %v", err)
+	}
+	return cacher
+}
+
+// lastUpdated returns the time of the last refresh run of the provider.
+func (tec *TimeExpirationCacher) lastUpdated(provider Provider) time.Time {
+	tec.stateLock.RLock()
+	defer tec.stateLock.RUnlock()
+	return tec.lastUpdate[provider]
+}
+
+// waitRefreshed waits until every provider of the cacher has finished its first refresh,
+// the cacher of the failing providers does not become ready.
+func waitRefreshed(t *testing.T, cacher *TimeExpirationCacher) {
+	t.Helper()
+	for started := time.Now(); time.Since(started) < time.Second*5; time.Sleep(time.Millisecond) {
+		loading := false
+		for _, pr := range cacher.Readiness().Providers {
+			loading = loading || pr == ReadinessLoading
+		}
+		if !loading {
+			return
+		}
+	}
+	t.Fatal("the providers have not finished the first refresh")
+}
+
 func TestTimeExpirationCacher_GetState(t *testing.T) {
 	t.Run("not refreshed before expiration", func(t *testing.T) {
-		cacher := NewTimeExpirationCacher(map[Provider]ProviderConfig{
+		cacher := newTestCacher(t, map[Provider]ProviderConfig{
 			Provider1: {
 				expiration: time.Minute * 10,
 				length:     300,
@@ -33,13 +103,14 @@ func TestTimeExpirationCacher_GetState(t *testing.T) {
 			},
 		})
 		cacher.Start()
+		<-cacher.Ready()
 		defer cacher.Stop()
 		state1 := cacher.GetState()
 		state2 := cacher.GetState()
 		assert.Equal(t, state1, state2)
 	})
 	t.Run("refreshed after expiration", func(t *testing.T) {
-		cacher := NewTimeExpirationCacher(map[Provider]ProviderConfig{
+		cacher := newTestCacher(t, map[Provider]ProviderConfig{
 			Provider1: {
 				expiration: time.Millisecond * 100,
 				length:     300,
@@ -60,6 +131,7 @@ func TestTimeExpirationCacher_GetState(t *testing.T) {
 			},
 		})
 		cacher.Start()
+		<-cacher.Ready()
 		defer cacher.Stop()
 		state1 := cacher.GetState()
 		time.Sleep(time.Millisecond * 200)
@@ -67,7 +139,7 @@ func TestTimeExpirationCacher_GetState(t *testing.T) {
 		assert.NotEqual(t, state1, state2)
 	})
 	t.Run("return fails in correct cases", func(t *testing.T) {
-		cacher := NewTimeExpirationCacher(map[Provider]ProviderConfig{
+		cacher := newTestCacher(t, map[Provider]ProviderConfig{
 			Provider1: {
 				expiration: time.Minute * 10,
 				length:     300,
@@ -82,6 +154,7 @@ func TestTimeExpirationCacher_GetState(t *testing.T) {
 			},
 		})
 		cacher.Start()
+		<-cacher.Ready()
 		defer cacher.Stop()
 		state := cacher.GetState()
 		state.Fails(Provider1)
@@ -89,7 +162,7 @@ func TestTimeExpirationCacher_GetState(t *testing.T) {
 		assert.False(t, state.Fails(Provider2))
 	})
 	t.Run("return empty when index is more than have", func(t *testing.T) {
-		cacher := NewTimeExpirationCacher(map[Provider]ProviderConfig{
+		cacher := newTestCacher(t, map[Provider]ProviderConfig{
 			Provider1: {
 				expiration: time.Minute * 5,
 				length:     100,
@@ -98,6 +171,7 @@ func TestTimeExpirationCacher_GetState(t *testing.T) {
 			},
 		})
 		cacher.Start()
+		<-cacher.Ready()
 		defer cacher.Stop()
 		state := cacher.GetState()
 		received := state.ContentItem(ContentAddress{
@@ -112,3 +186,634 @@ func TestTimeExpirationCacher_GetState(t *testing.T) {
 		assert.Nil(t, received)
 	})
 }
+
+func TestTimeExpirationCacher_Schedule(t *testing.T) {
+	t.Run("refreshed on every expiration", func(t *testing.T) {
+		clock := newFakeClock()
+		cacher := newTestCacher(t, map[Provider]ProviderConfig{
+			Provider1: {
+				expiration: time.Minute * 10,
+				length:     10,
+				client:     SampleContentProvider{Provider1},
+			},
+			Provider2: {
+				expiration: time.Minute * 5,
+				length:     10,
+				client:     SampleContentProvider{Provider2},
+			},
+		}, WithClock(clock))
+		cacher.Start()
+		<-cacher.Ready()
+		defer cacher.Stop()
+		clock.BlockUntil(2)
+		start := clock.Now()
+		assert.Equal(t, start, cacher.lastUpdated(Provider1))
+		assert.Equal(t, start, cacher.lastUpdated(Provider2))
+
+		clock.Advance(time.Minute * 5)
+		clock.BlockUntil(2)
+		assert.Equal(t, start, cacher.lastUpdated(Provider1))
+		assert.Equal(t, start.Add(time.Minute*5), cacher.lastUpdated(Provider2))
+
+		clock.Advance(time.Minute * 5)
+		clock.BlockUntil(2)
+		assert.Equal(t, start.Add(time.Minute*10), cacher.lastUpdated(Provider1))
+		assert.Equal(t, start.Add(time.Minute*10), cacher.lastUpdated(Provider2))
+
+		clock.Advance(time.Minute * 5)
+		clock.BlockUntil(2)
+		assert.Equal(t, start.Add(time.Minute*10), cacher.lastUpdated(Provider1))
+		assert.Equal(t, start.Add(time.Minute*15), cacher.lastUpdated(Provider2))
+	})
+	t.Run("jitter delays the refresh within the bounds", func(t *testing.T) {
+		clock := newFakeClock()
+		cacher := newTestCacher(t, map[Provider]ProviderConfig{
+			Provider1: {
+				expiration: time.Minute * 10,
+				jitter:     time.Minute,
+				length:     10,
+				client:     SampleContentProvider{Provider1},
+			},
+		}, WithClock(clock))
+		cacher.Start()
+		<-cacher.Ready()
+		defer cacher.Stop()
+		clock.BlockUntil(1)
+		start := clock.Now()
+		state1 := cacher.GetState()
+
+		clock.Advance(time.Minute*10 - time.Nanosecond)
+		assert.Equal(t, start, cacher.lastUpdated(Provider1))
+
+		clock.Advance(time.Minute)
+		clock.BlockUntil(1)
+		assert.Equal(t, clock.Now(), cacher.lastUpdated(Provider1))
+		assert.NotEqual(t, state1, cacher.GetState())
+	})
+	t.Run("stop cancels the scheduled refresh", func(t *testing.T) {
+		clock := newFakeClock()
+		cacher := newTestCacher(t, map[Provider]ProviderConfig{
+			Provider1: {
+				expiration: time.Minute * 10,
+				length:     10,
+				client:     SampleContentProvider{Provider1},
+			},
+		}, WithClock(clock))
+		cacher.Start()
+		<-cacher.Ready()
+		clock.BlockUntil(1)
+		cacher.Stop()
+		clock.BlockUntil(0)
+	})
+}
+
+func TestTimeExpirationCacher_GetSnapshot(t *testing.T) {
+	newCacher := func(clock Clock, keep int, grace time.Duration) *TimeExpirationCacher {
+		return newTestCacher(t, map[Provider]ProviderConfig{
+			Provider1: {
+				expiration: time.Minute,
+				length:     10,
+				client:     SampleContentProvider{Provider1},
+			},
+		}, WithClock(clock), WithSnapshots(keep, grace))
+	}
+	refresh := func(clock *fakeClock, cacher *TimeExpirationCacher) uint64 {
+		clock.Advance(time.Minute)
+		clock.BlockUntil(1)
+		return cacher.GetState().Version()
+	}
+	t.Run("the last snapshots are kept", func(t *testing.T) {
+		clock := newFakeClock()
+		cacher := newCacher(clock, 2, time.Hour)
+		cacher.Start()
+		<-cacher.Ready()
+		defer cacher.Stop()
+		clock.BlockUntil(1)
+		versions := []uint64{cacher.GetState().Version()}
+		for i := 0; i < 3; i++ {
+			versions = append(versions, refresh(clock, cacher))
+		}
+		_, ok := cacher.GetSnapshot(versions[0])
+		assert.False(t, ok)
+		for _, version := range versions[1:] {
+			state, ok := cacher.GetSnapshot(version)
+			assert.True(t, ok)
+			assert.Equal(t, version, state.Version())
+		}
+	})
+	t.Run("snapshots expire after the grace period", func(t *testing.T) {
+		clock := newFakeClock()
+		cacher := newCacher(clock, 10, time.Second*90)
+		cacher.Start()
+		<-cacher.Ready()
+		defer cacher.Stop()
+		clock.BlockUntil(1)
+		first := cacher.GetState().Version()
+		second := refresh(clock, cacher)
+		refresh(clock, cacher)
+		_, ok := cacher.GetSnapshot(first)
+		assert.True(t, ok)
+		refresh(clock, cacher)
+		_, ok = cacher.GetSnapshot(first)
+		assert.False(t, ok)
+		_, ok = cacher.GetSnapshot(second)
+		assert.True(t, ok)
+	})
+	t.Run("the snapshot keeps its failing providers", func(t *testing.T) {
+		clock := newFakeClock()
+		client := &switchableContentProvider{}
+		cacher := newTestCacher(t, map[Provider]ProviderConfig{
+			Provider1: {expiration: time.Minute * 10, maxStaleness: time.Minute * 15, length: 10, client: client},
+		}, WithClock(clock), WithSnapshots(10, time.Hour))
+		cacher.Start()
+		<-cacher.Ready()
+		defer cacher.Stop()
+		clock.BlockUntil(1)
+		client.setFail(true)
+		clock.Advance(time.Minute * 10)
+		clock.BlockUntil(1)
+		stale := cacher.GetState().Version()
+
+		clock.Advance(time.Minute * 5)
+		current := cacher.GetState()
+		assert.NotEqual(t, stale, current.Version())
+		assert.True(t, current.Fails(Provider1))
+		state, ok := cacher.GetSnapshot(stale)
+		assert.True(t, ok)
+		assert.False(t, state.Fails(Provider1))
+	})
+	t.Run("no snapshots by default", func(t *testing.T) {
+		clock := newFakeClock()
+		cacher := newTestCacher(t, map[Provider]ProviderConfig{
+			Provider1: {expiration: time.Minute, length: 10, client: SampleContentProvider{Provider1}},
+		}, WithClock(clock))
+		cacher.Start()
+		<-cacher.Ready()
+		defer cacher.Stop()
+		clock.BlockUntil(1)
+		first := cacher.GetState().Version()
+		current := refresh(clock, cacher)
+		assert.NotEqual(t, first, current)
+		_, ok := cacher.GetSnapshot(first)
+		assert.False(t, ok)
+		_, ok = cacher.GetSnapshot(current)
+		assert.True(t, ok)
+	})
+}
+
+func TestNextRefreshDelay(t *testing.T) {
+	pc := ProviderConfig{expiration: time.Minute, jitter: time.Second}
+	for i := 0; i < 100; i++ {
+		delay := nextRefreshDelay(pc)
+		assert.True(t, delay >= time.Minute && delay < time.Minute+time.Second, delay)
+	}
+	assert.Equal(t, time.Minute, nextRefreshDelay(ProviderConfig{expiration: time.Minute}))
+}
+
+func TestTimeExpirationCacher_Retry(t *testing.T) {
+	retry := RetryPolicy{
+		InitialDelay: time.Second,
+		Multiplier:   2,
+		MaxDelay:     time.Second * 5,
+		MaxAttempts:  4,
+	}
+	t.Run("failing provider is retried with backoff", func(t *testing.T) {
+		clock := newFakeClock()
+		cacher := newTestCacher(t, map[Provider]ProviderConfig{
+			Provider1: {
+				expiration: time.Minute * 10,
+				retry:      retry,
+				length:     10,
+				client:     failedContentProvider{},
+			},
+		}, WithClock(clock))
+		cacher.Start()
+		waitRefreshed(t, cacher)
+		defer cacher.Stop()
+		clock.BlockUntil(1)
+
+		for i, delay := range []time.Duration{time.Second, time.Second * 2, time.Second * 4, time.Second * 5} {
+			status := cacher.GetState().ProviderStatus(Provider1)
+			assert.Equal(t, ProviderStatus{
+				Failures:    i + 1,
+				Retrying:    true,
+				NextAttempt: clock.Now().Add(delay),
+				LastError:   "network error",
+			}, status)
+			clock.Advance(delay)
+			clock.BlockUntil(1)
+		}
+		status := cacher.GetState().ProviderStatus(Provider1)
+		assert.Equal(t, ProviderStatus{
+			Failures:    5,
+			Retrying:    false,
+			NextAttempt: clock.Now().Add(time.Minute * 10),
+			LastError:   "network error",
+		}, status)
+		assert.True(t, cacher.GetState().Fails(Provider1))
+	})
+	t.Run("recovered provider is back on the normal cadence", func(t *testing.T) {
+		clock := newFakeClock()
+		cacher := newTestCacher(t, map[Provider]ProviderConfig{
+			Provider1: {
+				expiration: time.Minute * 10,
+				retry:      retry,
+				length:     10,
+				client:     &flakyContentProvider{failures: 2},
+			},
+		}, WithClock(clock))
+		cacher.Start()
+		waitRefreshed(t, cacher)
+		defer cacher.Stop()
+		clock.BlockUntil(1)
+		assert.True(t, cacher.GetState().Fails(Provider1))
+
+		clock.Advance(time.Second)
+		clock.BlockUntil(1)
+		assert.Equal(t, 2, cacher.GetState().ProviderStatus(Provider1).Failures)
+
+		clock.Advance(time.Second * 2)
+		clock.BlockUntil(1)
+		state := cacher.GetState()
+		assert.False(t, state.Fails(Provider1))
+		assert.Equal(t, ProviderStatus{
+			NextAttempt: clock.Now().Add(time.Minute * 10),
+			LastSuccess: clock.Now(),
+		}, state.ProviderStatus(Provider1))
+	})
+	t.Run("healthy provider ignores the retry policy", func(t *testing.T) {
+		clock := newFakeClock()
+		cacher := newTestCacher(t, map[Provider]ProviderConfig{
+			Provider1: {
+				expiration: time.Minute * 10,
+				retry:      retry,
+				length:     10,
+				client:     SampleContentProvider{Provider1},
+			},
+		}, WithClock(clock))
+		cacher.Start()
+		waitRefreshed(t, cacher)
+		defer cacher.Stop()
+		clock.BlockUntil(1)
+		assert.Equal(t, ProviderStatus{
+			NextAttempt: clock.Now().Add(time.Minute * 10),
+			LastSuccess: clock.Now(),
+		}, cacher.GetState().ProviderStatus(Provider1))
+	})
+}
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	t.Run("zero policy does not retry", func(t *testing.T) {
+		_, ok := RetryPolicy{}.backoff(1)
+		assert.False(t, ok)
+	})
+	t.Run("no failures no retry", func(t *testing.T) {
+		_, ok := RetryPolicy{InitialDelay: time.Second}.backoff(0)
+		assert.False(t, ok)
+	})
+	t.Run("constant delay when multiplier is not set", func(t *testing.T) {
+		delay, ok := RetryPolicy{InitialDelay: time.Second}.backoff(10)
+		assert.True(t, ok)
+		assert.Equal(t, time.Second, delay)
+	})
+	t.Run("huge delays do not overflow", func(t *testing.T) {
+		delay, ok := RetryPolicy{InitialDelay: time.Second, Multiplier: 10}.backoff(100)
+		assert.True(t, ok)
+		assert.True(t, delay > 0)
+	})
+}
+
+func TestTimeExpirationCacher_Staleness(t *testing.T) {
+	t.Run("failing provider serves stale content within the budget", func(t *testing.T) {
+		clock := newFakeClock()
+		client := &switchableContentProvider{}
+		cacher := newTestCacher(t, map[Provider]ProviderConfig{
+			Provider1: {
+				expiration:   time.Minute * 10,
+				maxStaleness: time.Minute * 15,
+				length:       10,
+				client:       client,
+			},
+		}, WithClock(clock))
+		cacher.Start()
+		waitRefreshed(t, cacher)
+		defer cacher.Stop()
+		clock.BlockUntil(1)
+		state := cacher.GetState()
+		assert.Equal(t, HealthOK, state.Health(Provider1))
+		item := state.ContentItem(ContentAddress{Provider: Provider1, Index: 0})
+
+		client.setFail(true)
+		clock.Advance(time.Minute * 10)
+		clock.BlockUntil(1)
+		state = cacher.GetState()
+		assert.False(t, state.Fails(Provider1))
+		assert.Equal(t, HealthDegraded, state.Health(Provider1))
+		assert.Equal(t, item, state.ContentItem(ContentAddress{Provider: Provider1, Index: 0}))
+
+		clock.Advance(time.Minute * 5)
+		assert.False(t, state.Fails(Provider1), "the state keeps the failing providers it was set with")
+		next := cacher.GetState()
+		assert.True(t, next.Fails(Provider1))
+		assert.Equal(t, HealthFailed, next.Health(Provider1))
+		assert.Equal(t, state.Version()+1, next.Version())
+
+		client.setFail(false)
+		clock.Advance(time.Minute * 5)
+		clock.BlockUntil(1)
+		state = cacher.GetState()
+		assert.False(t, state.Fails(Provider1))
+		assert.Equal(t, HealthOK, state.Health(Provider1))
+	})
+	t.Run("provider without content fails immediately", func(t *testing.T) {
+		clock := newFakeClock()
+		cacher := newTestCacher(t, map[Provider]ProviderConfig{
+			Provider1: {
+				expiration:   time.Minute * 10,
+				maxStaleness: time.Minute * 15,
+				length:       10,
+				client:       failedContentProvider{},
+			},
+		}, WithClock(clock))
+		cacher.Start()
+		waitRefreshed(t, cacher)
+		defer cacher.Stop()
+		state := cacher.GetState()
+		assert.True(t, state.Fails(Provider1))
+		assert.Equal(t, HealthFailed, state.Health(Provider1))
+	})
+	t.Run("provider without budget fails immediately", func(t *testing.T) {
+		clock := newFakeClock()
+		client := &switchableContentProvider{}
+		cacher := newTestCacher(t, map[Provider]ProviderConfig{
+			Provider1: {
+				expiration: time.Minute * 10,
+				length:     10,
+				client:     client,
+			},
+		}, WithClock(clock))
+		cacher.Start()
+		waitRefreshed(t, cacher)
+		defer cacher.Stop()
+		clock.BlockUntil(1)
+		client.setFail(true)
+		clock.Advance(time.Minute * 10)
+		clock.BlockUntil(1)
+		assert.Equal(t, HealthFailed, cacher.GetState().Health(Provider1))
+	})
+}
+
+func TestNewTimeExpirationCacher(t *testing.T) {
+	t.Run("invalid provider configs", func(t *testing.T) {
+		_, err := NewTimeExpirationCacher(map[Provider]ProviderConfig{
+			Provider1: {expiration: 0, length: 10, client: SampleContentProvider{Provider1}},
+			Provider2: {expiration: time.Minute, jitter: -time.Second, length: -1},
+		})
+		assert.Equal(t, ConfigError{
+			`provider "1": expiration should be positive`,
+			`provider "2": client is not set`,
+			`provider "2": jitter should not be negative`,
+			`provider "2": length should be positive`,
+		}, err)
+	})
+}
+
+// hangingContentProvider blocks until released, ignoring any cancellation.
+type hangingContentProvider struct {
+	release chan struct{}
+}
+
+func (cp hangingContentProvider) GetContent(userIP string, count int) ([]*ContentItem, error) {
+	<-cp.release
+	return nil, errors.New("released")
+}
+
+// contextContentProvider blocks until the context is done and reports it.
+type contextContentProvider struct {
+	started   chan struct{}
+	cancelled chan error
+}
+
+func (cp contextContentProvider) GetContent(userIP string, count int) ([]*ContentItem, error) {
+	return nil, errors.New("not supported")
+}
+
+func (cp contextContentProvider) GetContentContext(ctx context.Context, userIP string, count int) ([]*ContentItem, error) {
+	close(cp.started)
+	<-ctx.Done()
+	cp.cancelled <- ctx.Err()
+	return nil, ctx.Err()
+}
+
+func TestTimeExpirationCacher_Cancellation(t *testing.T) {
+	t.Run("hanging fetch fails after the fetch timeout", func(t *testing.T) {
+		client := hangingContentProvider{release: make(chan struct{})}
+		defer close(client.release)
+		cacher := newTestCacher(t, map[Provider]ProviderConfig{
+			Provider1: {
+				expiration:   time.Minute * 10,
+				fetchTimeout: time.Millisecond * 50,
+				length:       10,
+				client:       client,
+			},
+		})
+		cacher.Start()
+		waitRefreshed(t, cacher)
+		defer cacher.Stop()
+		state := cacher.GetState()
+		assert.True(t, state.Fails(Provider1))
+		assert.Equal(t, context.DeadlineExceeded.Error(), state.ProviderStatus(Provider1).LastError)
+	})
+	t.Run("stop cancels the fetch in flight", func(t *testing.T) {
+		client := contextContentProvider{started: make(chan struct{}), cancelled: make(chan error, 1)}
+		cacher := newTestCacher(t, map[Provider]ProviderConfig{
+			Provider1: {
+				expiration: time.Minute * 10,
+				length:     10,
+				client:     client,
+			},
+		})
+		started := make(chan struct{})
+		go func() {
+			cacher.Start()
+			close(started)
+		}()
+		<-client.started
+		cacher.Stop()
+		assert.Equal(t, context.Canceled, <-client.cancelled)
+		<-started
+		assert.Equal(t, ProviderStatus{}, cacher.GetState().ProviderStatus(Provider1))
+	})
+	t.Run("set provider does not wait for the hanging fetch", func(t *testing.T) {
+		cacher := newTestCacher(t, map[Provider]ProviderConfig{
+			Provider1: {expiration: time.Minute * 10, length: 10, client: SampleContentProvider{Provider1}},
+		})
+		cacher.loadWait = time.Millisecond * 50
+		cacher.Start()
+		<-cacher.Ready()
+		defer cacher.Stop()
+		client := contextContentProvider{started: make(chan struct{}), cancelled: make(chan error, 1)}
+		assert.NoError(t, cacher.SetProvider(Provider2, ProviderConfig{expiration: time.Minute * 10, length: 10, client: client}))
+		assert.Equal(t, ReadinessLoading, cacher.Readiness().Providers[Provider2])
+	})
+	t.Run("set providers waits for all of them at once", func(t *testing.T) {
+		cacher := newTestCacher(t, map[Provider]ProviderConfig{
+			Provider1: {expiration: time.Minute * 10, length: 10, client: SampleContentProvider{Provider1}},
+		})
+		cacher.loadWait = time.Millisecond * 200
+		cacher.Start()
+		<-cacher.Ready()
+		defer cacher.Stop()
+		providerConfigs := make(map[Provider]ProviderConfig)
+		for _, p := range []Provider{Provider2, Provider3} {
+			client := contextContentProvider{started: make(chan struct{}), cancelled: make(chan error, 1)}
+			providerConfigs[p] = ProviderConfig{expiration: time.Minute * 10, length: 10, client: client}
+		}
+		started := time.Now()
+		assert.NoError(t, cacher.SetProviders(providerConfigs))
+		assert.Less(t, int64(time.Since(started)), int64(time.Millisecond*350))
+		assert.Equal(t, ReadinessLoading, cacher.Readiness().Providers[Provider3])
+	})
+	t.Run("set providers sets none of them if one is not valid", func(t *testing.T) {
+		cacher := newTestCacher(t, map[Provider]ProviderConfig{
+			Provider1: {expiration: time.Minute * 10, length: 10, client: SampleContentProvider{Provider1}},
+		})
+		cacher.Start()
+		<-cacher.Ready()
+		defer cacher.Stop()
+		assert.Error(t, cacher.SetProviders(map[Provider]ProviderConfig{
+			Provider2: {expiration: time.Minute * 10, length: 10, client: SampleContentProvider{Provider2}},
+			Provider3: {expiration: time.Minute * 10},
+		}))
+		_, ok := cacher.Readiness().Providers[Provider2]
+		assert.False(t, ok)
+	})
+}
+
+func TestAsContextClient(t *testing.T) {
+	t.Run("context client is used as is", func(t *testing.T) {
+		client := contextContentProvider{}
+		assert.Equal(t, client, AsContextClient(client))
+	})
+	t.Run("adapter returns the result", func(t *testing.T) {
+		content, err := AsContextClient(SampleContentProvider{Provider1}).GetContentContext(context.Background(), "", 3)
+		assert.NoError(t, err)
+		assert.Len(t, content, 3)
+	})
+	t.Run("adapter returns when the context is done", func(t *testing.T) {
+		client := hangingContentProvider{release: make(chan struct{})}
+		defer close(client.release)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer cancel()
+		_, err := AsContextClient(client).GetContentContext(ctx, "", 3)
+		assert.Equal(t, context.DeadlineExceeded, err)
+	})
+}
+
+func TestTimeExpirationCacher_Breaker(t *testing.T) {
+	clock := newFakeClock()
+	breaker, err := NewCircuitBreaker(BreakerSettings{FailureThreshold: 1, OpenTimeout: Duration(time.Minute * 15)}, clock)
+	assert.NoError(t, err)
+	client := &switchableContentProvider{}
+	cacher := newTestCacher(t, map[Provider]ProviderConfig{
+		Provider1: {
+			expiration:   time.Minute * 10,
+			maxStaleness: time.Hour,
+			length:       10,
+			client:       client,
+			middleware:   []ClientMiddleware{breaker.Middleware()},
+			breaker:      breaker,
+		},
+	}, WithClock(clock))
+	cacher.Start()
+	<-cacher.Ready()
+	defer cacher.Stop()
+	clock.BlockUntil(1)
+	state := cacher.GetState()
+	assert.False(t, state.Fails(Provider1))
+
+	client.setFail(true)
+	clock.Advance(time.Minute * 10)
+	clock.BlockUntil(1)
+	state = cacher.GetState()
+	assert.True(t, state.Fails(Provider1))
+	assert.Equal(t, HealthFailed, state.Health(Provider1))
+
+	client.setFail(false)
+	clock.Advance(time.Minute * 15)
+	assert.True(t, state.Fails(Provider1), "the state keeps the failing providers it was set with")
+	state = cacher.GetState()
+	assert.False(t, state.Fails(Provider1))
+	assert.Equal(t, HealthDegraded, state.Health(Provider1))
+}
+
+func TestTimeExpirationCacher_Expiry(t *testing.T) {
+	clock := newFakeClock()
+	now := clock.Now()
+	client := &countingContentProvider{switchableContentProvider: switchableContentProvider{content: staticContentProvider{
+		{ID: "expired", Expiry: now.Add(-time.Minute)},
+		{ID: "1m", Expiry: now.Add(time.Minute)},
+		{ID: "never"},
+		{ID: "2m", Expiry: now.Add(time.Minute * 2)},
+	}}}
+	cacher := newTestCacher(t, map[Provider]ProviderConfig{
+		Provider1: {expiration: time.Hour, minFreshShare: 0.5, length: 10, client: client},
+	}, WithClock(clock))
+	cacher.Start()
+	<-cacher.Ready()
+	defer cacher.Stop()
+	clock.BlockUntil(1)
+	ids := func() (ids []string) {
+		state := cacher.GetState()
+		for i := 0; i < state.Available(Provider1); i++ {
+			ids = append(ids, state.ContentItem(ContentAddress{Provider: Provider1, Index: i}).ID)
+		}
+		return
+	}
+
+	t.Run("the expired items are not cached", func(t *testing.T) {
+		assert.Equal(t, []string{"1m", "never", "2m"}, ids())
+	})
+	t.Run("the items are evicted as they expire", func(t *testing.T) {
+		clock.Advance(time.Minute)
+		clock.BlockUntil(1)
+		assert.Equal(t, []string{"never", "2m"}, ids())
+		assert.Equal(t, 1, client.callCount())
+	})
+	t.Run("the provider is refreshed early when too few items are left", func(t *testing.T) {
+		clock.Advance(time.Minute)
+		clock.BlockUntil(1)
+		assert.Equal(t, []string{"never"}, ids())
+		assert.Equal(t, 2, client.callCount())
+	})
+}
+
+func TestTimeExpirationCacher_ExpiryBatches(t *testing.T) {
+	clock := newFakeClock()
+	now := clock.Now()
+	cacher := newTestCacher(t, map[Provider]ProviderConfig{
+		Provider1: {expiration: time.Hour, length: 10, client: staticContentProvider{
+			{ID: "10s", Expiry: now.Add(time.Second * 10)},
+			{ID: "70s", Expiry: now.Add(time.Second * 70)},
+			{ID: "80s", Expiry: now.Add(time.Second * 80)},
+			{ID: "90s", Expiry: now.Add(time.Second * 90)},
+			{ID: "never"},
+		}},
+	}, WithClock(clock), WithSnapshots(1, time.Hour))
+	cacher.Start()
+	<-cacher.Ready()
+	defer cacher.Stop()
+	clock.BlockUntil(1)
+	state := cacher.GetState()
+	version := state.Version()
+	assert.Equal(t, 4, state.Available(Provider1), "the item expiring within the minute is not cached")
+
+	clock.Advance(time.Minute)
+	clock.BlockUntil(1)
+	state = cacher.GetState()
+	assert.Equal(t, version+1, state.Version(), "the items expiring within the minute are evicted at once")
+	assert.Equal(t, 1, state.Available(Provider1))
+	assert.Equal(t, "never", state.ContentItem(ContentAddress{Provider: Provider1, Index: 0}).ID)
+	_, ok := cacher.GetSnapshot(version)
+	assert.True(t, ok)
+}
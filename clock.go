@@ -0,0 +1,37 @@
+package main
+
+import "time"
+
+// Clock abstracts the source of time, so the components depending on the schedule can be tested without sleeping.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the timer created by the Clock.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	timer *time.Timer
+}
+
+func (rt realTimer) C() <-chan time.Time {
+	return rt.timer.C
+}
+
+func (rt realTimer) Stop() bool {
+	return rt.timer.Stop()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// fakeClock is the manually driven Clock for the tests.
+type fakeClock struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func newFakeClock() *fakeClock {
+	fc := &fakeClock{now: time.Date(2020, 9, 24, 10, 0, 0, 0, time.UTC)}
+	fc.cond = sync.NewCond(&fc.mu)
+	return fc
+}
+
+func (fc *fakeClock) Now() time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.now
+}
+
+func (fc *fakeClock) NewTimer(d time.Duration) Timer {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	t := &fakeTimer{clock: fc, deadline: fc.now.Add(d), c: make(chan time.Time, 1)}
+	fc.timers = append(fc.timers, t)
+	fc.cond.Broadcast()
+	return t
+}
+
+// Advance moves the time forward and fires the timers which deadline has passed.
+func (fc *fakeClock) Advance(d time.Duration) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.now = fc.now.Add(d)
+	pending := fc.timers[:0]
+	for _, t := range fc.timers {
+		if t.deadline.After(fc.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.c <- fc.now
+	}
+	fc.timers = pending
+	fc.cond.Broadcast()
+}
+
+// BlockUntil waits until there are exactly n timers waiting on the clock.
+func (fc *fakeClock) BlockUntil(n int) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	for len(fc.timers) != n {
+		fc.cond.Wait()
+	}
+}
+
+func (fc *fakeClock) stop(t *fakeTimer) bool {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	for i, pending := range fc.timers {
+		if pending == t {
+			fc.timers = append(fc.timers[:i], fc.timers[i+1:]...)
+			fc.cond.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	clock    *fakeClock
+	deadline time.Time
+	c        chan time.Time
+}
+
+func (ft *fakeTimer) C() <-chan time.Time {
+	return ft.c
+}
+
+func (ft *fakeTimer) Stop() bool {
+	return ft.clock.stop(ft)
+}
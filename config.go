@@ -3,8 +3,72 @@ package main
 type ContentMix []ContentConfig
 
 type ContentConfig struct {
-	Type     Provider
-	Fallback *Provider
+	Type     Provider  `json:"type"`
+	Fallback *Provider `json:"fallback,omitempty"`
+	// Fallbacks are tried in order after the Fallback, until one of them can serve the slot.
+	Fallbacks []Provider `json:"fallbacks,omitempty"`
+	// OnFailure is the policy for the slot neither the provider nor the fallbacks can serve,
+	// PolicyTruncate for the mix slots and PolicySkip for the pinned slots if not set.
+	OnFailure FailurePolicy `json:"on_failure,omitempty"`
+}
+
+// FailurePolicy tells what happens to the slot neither the provider nor the fallbacks can serve.
+type FailurePolicy string
+
+const (
+	// PolicyTruncate ends the page at the failed slot.
+	PolicyTruncate FailurePolicy = "truncate"
+	// PolicySkip leaves the failed slot out, the next items move up.
+	PolicySkip FailurePolicy = "skip"
+	// PolicyPlaceholder puts the placeholder item in place of the failed slot.
+	PolicyPlaceholder FailurePolicy = "placeholder"
+)
+
+func (fp FailurePolicy) valid() bool {
+	switch fp {
+	case "", PolicyTruncate, PolicySkip, PolicyPlaceholder:
+		return true
+	default:
+		return false
+	}
+}
+
+// or returns the policy, or the given one if the policy is not set.
+func (fp FailurePolicy) or(policy FailurePolicy) FailurePolicy {
+	if fp == "" {
+		return policy
+	}
+	return fp
+}
+
+// withPolicy returns the mix which slots without the failure policy have the given one.
+func (cm ContentMix) withPolicy(policy FailurePolicy) ContentMix {
+	mix := make(ContentMix, len(cm))
+	for i, cc := range cm {
+		mix[i] = cc
+		mix[i].OnFailure = cc.OnFailure.or(policy)
+	}
+	return mix
+}
+
+// chain returns the providers which can serve the slot in the order they are tried, the slot provider is the first one.
+func (cc ContentConfig) chain() []Provider {
+	chain := make([]Provider, 0, 2+len(cc.Fallbacks))
+	chain = append(chain, cc.Type)
+	if cc.Fallback != nil {
+		chain = append(chain, *cc.Fallback)
+	}
+	return append(chain, cc.Fallbacks...)
+}
+
+// PinnedSlot reserves the positions of the feed for the provider, e.g. sponsored, over the regular rotation of the mix.
+// The pinned slot which neither the provider nor the fallbacks can serve is skipped, instead of ending the page.
+type PinnedSlot struct {
+	ContentConfig
+	// Positions are the zero based positions of the feed the slot takes.
+	Positions []int `json:"positions,omitempty"`
+	// Every makes the slot take every n-th position of the feed: n-1, 2n-1 and so on.
+	Every int `json:"every,omitempty"`
 }
 
 var (
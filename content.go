@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"math/rand"
 	"strconv"
 	"time"
@@ -11,6 +12,46 @@ type Client interface {
 	GetContent(userIP string, count int) ([]*ContentItem, error)
 }
 
+// ContextClient represents a provider's client which fetch can be cancelled or limited in time with the context.
+type ContextClient interface {
+	GetContentContext(ctx context.Context, userIP string, count int) ([]*ContentItem, error)
+}
+
+// AsContextClient returns the client itself if it supports the context, or the adapter for it otherwise.
+func AsContextClient(c Client) ContextClient {
+	if cc, ok := c.(ContextClient); ok {
+		return cc
+	}
+	return contextClientAdapter{c}
+}
+
+// contextClientAdapter runs the fetch of the Client not supporting the context in a separate goroutine,
+// and returns as soon as the context is done. The hanging fetch is abandoned then, and its result is dropped.
+type contextClientAdapter struct {
+	client Client
+}
+
+func (a contextClientAdapter) GetContentContext(ctx context.Context, userIP string, count int) ([]*ContentItem, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	type result struct {
+		content []*ContentItem
+		err     error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		content, err := a.client.GetContent(userIP, count)
+		resc <- result{content, err}
+	}()
+	select {
+	case res := <-resc:
+		return res.content, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
 // ContentItem represent one piece of content fetched from a provider
 type ContentItem struct {
 	ID      string    `json:"id"`
@@ -19,8 +60,13 @@ type ContentItem struct {
 	Summary string    `json:"summary"`
 	Link    string    `json:"link"`
 	Expiry  time.Time `json:"expiry"`
+	// Type is PlaceholderItemType for the placeholder of the slot which could not be served, and empty for the content.
+	Type string `json:"type,omitempty"`
 }
 
+// PlaceholderItemType is the type of the item put in place of the slot which could not be served.
+const PlaceholderItemType = "placeholder"
+
 // Provider represent the 3rd party from which we are getting content
 type Provider string
 
@@ -33,6 +79,9 @@ var (
 	Provider3 = Provider("3")
 )
 
+// sampleItemTTL is how long the items of the SampleContentProvider are fresh.
+const sampleItemTTL = time.Hour
+
 // SampleContentProvider is an example for a Provider's client
 type SampleContentProvider struct {
 	Source Provider
@@ -46,7 +95,7 @@ func (cp SampleContentProvider) GetContent(userIP string, count int) ([]*Content
 			ID:     strconv.Itoa(rand.Int()),
 			Title:  "title",
 			Source: string(cp.Source),
-			Expiry: time.Now(),
+			Expiry: time.Now().Add(sampleItemTTL),
 		}
 
 	}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+)
+
+// ErrCursorExpired is returned for the cursor which state is not kept anymore, the listing should start over.
+var ErrCursorExpired error = clientError{
+	code:    CodeCursorExpired,
+	status:  http.StatusGone,
+	message: "the cursor has expired, start the listing from the first page",
+}
+
+// errInvalidCursor is returned for the cursor which cannot be decoded.
+var errInvalidCursor = ParameterError{Parameter: "cursor", Reason: "is not valid"}
+
+// Cursor points to the page of the listing, it keeps the version of the state the listing started with,
+// the version of the layout of the sequencer and the offset of the page in it.
+type Cursor struct {
+	Version uint64
+	Layout  uint64
+	Offset  int
+}
+
+// String encodes the cursor to the opaque URL safe string.
+func (c Cursor) String() string {
+	bb := make([]byte, 3*binary.MaxVarintLen64)
+	n := binary.PutUvarint(bb, c.Version)
+	n += binary.PutUvarint(bb[n:], c.Layout)
+	n += binary.PutUvarint(bb[n:], uint64(c.Offset))
+	return base64.RawURLEncoding.EncodeToString(bb[:n])
+}
+
+// ParseCursor decodes the cursor encoded with Cursor.String.
+func ParseCursor(s string) (Cursor, error) {
+	bb, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, errInvalidCursor
+	}
+	var fields [3]uint64
+	read := 0
+	for i := range fields {
+		n := 0
+		fields[i], n = binary.Uvarint(bb[read:])
+		if n <= 0 {
+			return Cursor{}, errInvalidCursor
+		}
+		read += n
+	}
+	if read != len(bb) || fields[2] > uint64(maxInt) {
+		return Cursor{}, errInvalidCursor
+	}
+	return Cursor{Version: fields[0], Layout: fields[1], Offset: int(fields[2])}, nil
+}
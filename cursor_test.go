@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCursor(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		for _, cursor := range []Cursor{{}, {Version: 1, Offset: 10}, {Version: 2, Layout: 3, Offset: 4}, {Version: 1<<64 - 1, Offset: maxInt}} {
+			parsed, err := ParseCursor(cursor.String())
+			assert.NoError(t, err)
+			assert.Equal(t, cursor, parsed)
+		}
+	})
+	t.Run("invalid cursor", func(t *testing.T) {
+		valid := Cursor{Version: 100, Offset: 10}.String()
+		for _, cursor := range []string{"", "!!!", valid + "AA", valid[:1]} {
+			_, err := ParseCursor(cursor)
+			assert.Equal(t, errInvalidCursor, err, cursor)
+		}
+	})
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// The keys the duplicates are found by.
+const (
+	linkKey  = "link"
+	titleKey = "title"
+)
+
+// The rules of choosing the duplicate to keep.
+const (
+	// priorityWinner keeps the item of the provider listed first in the priority.
+	priorityWinner = "priority"
+	// latestExpiryWinner keeps the item expiring last, the priority decides between the items expiring at once.
+	latestExpiryWinner = "latest_expiry"
+)
+
+// trackingParams are the query parameters of the links which do not change the content they point to.
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"dclid":   true,
+	"msclkid": true,
+	"igshid":  true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"_ga":     true,
+}
+
+// DedupSettings configures the Deduplicator.
+type DedupSettings struct {
+	// Keys are "link" and "title", the items having the same normalized link or the same title fingerprint are duplicates.
+	// Only the link is used if not set.
+	Keys []string `json:"keys,omitempty"`
+	// Winner is the rule of choosing the duplicate to keep: "priority" (the default) or "latest_expiry".
+	Winner string `json:"winner,omitempty"`
+	// Priority lists the providers from the most to the least preferred, the providers not listed go after them by name.
+	Priority []Provider `json:"priority,omitempty"`
+}
+
+func (ds DedupSettings) validate(providers map[Provider]ProviderSettings) (problems []string) {
+	for _, p := range ds.Priority {
+		if _, ok := providers[p]; !ok {
+			problems = append(problems, fmt.Sprintf("dedup priority: unknown provider %q", p))
+		}
+	}
+	for _, key := range ds.Keys {
+		if key != linkKey && key != titleKey {
+			problems = append(problems, fmt.Sprintf("unknown dedup key %q, expected %q or %q", key, linkKey, titleKey))
+		}
+	}
+	switch ds.Winner {
+	case "", priorityWinner, latestExpiryWinner:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown dedup winner %q, expected %q or %q",
+			ds.Winner, priorityWinner, latestExpiryWinner))
+	}
+	return
+}
+
+// Deduplicator drops the items of the same story served by several providers, or several times by one provider.
+type Deduplicator struct {
+	byLink, byTitle bool
+	latestExpiry    bool
+	rank            map[Provider]int
+}
+
+// NewDeduplicator the constructor of the Deduplicator, the unknown keys are ignored.
+func NewDeduplicator(settings DedupSettings) *Deduplicator {
+	d := &Deduplicator{
+		latestExpiry: settings.Winner == latestExpiryWinner,
+		rank:         make(map[Provider]int, len(settings.Priority)),
+	}
+	if len(settings.Keys) == 0 {
+		d.byLink = true
+	}
+	for _, key := range settings.Keys {
+		d.byLink = d.byLink || key == linkKey
+		d.byTitle = d.byTitle || key == titleKey
+	}
+	for i, p := range settings.Priority {
+		if _, ok := d.rank[p]; !ok {
+			d.rank[p] = i
+		}
+	}
+	return d
+}
+
+// candidate is the item with its place in the content.
+type candidate struct {
+	provider Provider
+	index    int
+	item     *ContentItem
+}
+
+// Deduplicate returns the content without the duplicates, every provider keeps its items in the same order.
+func (d *Deduplicator) Deduplicate(content map[Provider][]*ContentItem) map[Provider][]*ContentItem {
+	var candidates []candidate
+	for p, items := range content {
+		for i, item := range items {
+			if item != nil {
+				candidates = append(candidates, candidate{provider: p, index: i, item: item})
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return d.wins(candidates[i], candidates[j])
+	})
+	seen := make(map[string]bool, len(candidates))
+	keep := make(map[*ContentItem]bool, len(candidates))
+	for _, c := range candidates {
+		keys := d.keys(c.item)
+		duplicate := false
+		for _, key := range keys {
+			duplicate = duplicate || seen[key]
+		}
+		if duplicate {
+			continue
+		}
+		for _, key := range keys {
+			seen[key] = true
+		}
+		keep[c.item] = true
+	}
+	deduplicated := make(map[Provider][]*ContentItem, len(content))
+	for p, items := range content {
+		kept := make([]*ContentItem, 0, len(items))
+		for _, item := range items {
+			if keep[item] {
+				kept = append(kept, item)
+			}
+		}
+		deduplicated[p] = kept
+	}
+	return deduplicated
+}
+
+// wins tells if the candidate a is kept rather than b.
+func (d *Deduplicator) wins(a, b candidate) bool {
+	if d.latestExpiry && !a.item.Expiry.Equal(b.item.Expiry) {
+		return a.item.Expiry.After(b.item.Expiry)
+	}
+	if a.provider != b.provider {
+		rankA, okA := d.rank[a.provider]
+		rankB, okB := d.rank[b.provider]
+		switch {
+		case okA && okB:
+			return rankA < rankB
+		case okA != okB:
+			return okA
+		default:
+			return a.provider < b.provider
+		}
+	}
+	return a.index < b.index
+}
+
+func (d *Deduplicator) keys(item *ContentItem) []string {
+	var keys []string
+	if d.byLink {
+		if link := normalizeLink(item.Link); link != "" {
+			keys = append(keys, "link:"+link)
+		}
+	}
+	if d.byTitle {
+		if fingerprint := titleFingerprint(item.Title); fingerprint != "" {
+			keys = append(keys, "title:"+fingerprint)
+		}
+	}
+	return keys
+}
+
+// normalizeLink returns the link without the scheme, the fragment, the tracking parameters and the trailing slashes,
+// with the lower case host and the sorted query parameters.
+func normalizeLink(link string) string {
+	link = strings.TrimSpace(link)
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" {
+		return strings.TrimRight(link, "/")
+	}
+	query := u.Query()
+	for param := range query {
+		if strings.HasPrefix(strings.ToLower(param), "utm_") || trackingParams[strings.ToLower(param)] {
+			query.Del(param)
+		}
+	}
+	normalized := strings.ToLower(u.Host) + strings.TrimRight(u.EscapedPath(), "/")
+	if len(query) != 0 {
+		normalized += "?" + query.Encode()
+	}
+	return normalized
+}
+
+// titleFingerprint returns the lower case words of the title separated with single spaces, the punctuation is dropped.
+func titleFingerprint(title string) string {
+	words := strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// staticContentProvider returns the same items on every call.
+type staticContentProvider []*ContentItem
+
+func (cp staticContentProvider) GetContent(userIP string, count int) ([]*ContentItem, error) {
+	if count < len(cp) {
+		return cp[:count], nil
+	}
+	return cp, nil
+}
+
+func TestNormalizeLink(t *testing.T) {
+	for link, expected := range map[string]string{
+		"https://example.com/news/1":                              "example.com/news/1",
+		"http://Example.COM/news/1/":                              "example.com/news/1",
+		"https://example.com/news/1?utm_source=x&utm_medium=y":    "example.com/news/1",
+		"https://example.com/news/1?id=2&fbclid=abc&a=1#comments": "example.com/news/1?a=1&id=2",
+		"https://example.com/":                                    "example.com",
+		"not a link/":                                             "not a link",
+		"":                                                        "",
+	} {
+		assert.Equal(t, expected, normalizeLink(link), link)
+	}
+}
+
+func TestTitleFingerprint(t *testing.T) {
+	assert.Equal(t, "breaking the news is out", titleFingerprint("  BREAKING: The news is out!  "))
+	assert.Equal(t, titleFingerprint("Hello, world"), titleFingerprint("hello world."))
+	assert.Equal(t, "", titleFingerprint("?!"))
+}
+
+func TestDeduplicator_Deduplicate(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	a1 := &ContentItem{ID: "a1", Title: "Story A", Link: "https://example.com/a", Expiry: now}
+	a2 := &ContentItem{ID: "a2", Title: "Story A!", Link: "http://example.com/a/?utm_source=2", Expiry: now.Add(time.Hour)}
+	b1 := &ContentItem{ID: "b1", Title: "Story B", Link: "https://example.com/b"}
+	b2 := &ContentItem{ID: "b2", Title: "story b", Link: "https://other.com/b"}
+	c2 := &ContentItem{ID: "c2", Title: "Story C", Link: "https://example.com/c"}
+	content := map[Provider][]*ContentItem{
+		Provider1: {a1, b1},
+		Provider2: {b2, a2, c2},
+	}
+
+	t.Run("by link, the first provider by name wins by default", func(t *testing.T) {
+		assert.Equal(t, map[Provider][]*ContentItem{
+			Provider1: {a1, b1},
+			Provider2: {b2, c2},
+		}, NewDeduplicator(DedupSettings{}).Deduplicate(content))
+	})
+	t.Run("by link and title", func(t *testing.T) {
+		assert.Equal(t, map[Provider][]*ContentItem{
+			Provider1: {a1, b1},
+			Provider2: {c2},
+		}, NewDeduplicator(DedupSettings{Keys: []string{linkKey, titleKey}}).Deduplicate(content))
+	})
+	t.Run("the priority decides", func(t *testing.T) {
+		assert.Equal(t, map[Provider][]*ContentItem{
+			Provider1: {},
+			Provider2: {b2, a2, c2},
+		}, NewDeduplicator(DedupSettings{
+			Keys:     []string{titleKey},
+			Priority: []Provider{Provider2},
+		}).Deduplicate(content))
+	})
+	t.Run("the latest expiry wins", func(t *testing.T) {
+		assert.Equal(t, map[Provider][]*ContentItem{
+			Provider1: {b1},
+			Provider2: {b2, a2, c2},
+		}, NewDeduplicator(DedupSettings{Winner: latestExpiryWinner}).Deduplicate(content))
+	})
+	t.Run("duplicates of one provider", func(t *testing.T) {
+		assert.Equal(t, map[Provider][]*ContentItem{
+			Provider1: {a1, b1},
+		}, NewDeduplicator(DedupSettings{}).Deduplicate(map[Provider][]*ContentItem{
+			Provider1: {a1, b1, a2},
+		}))
+	})
+}
+
+func TestTimeExpirationCacher_Deduplicate(t *testing.T) {
+	a := &ContentItem{ID: "a", Link: "https://example.com/a"}
+	b := &ContentItem{ID: "b", Link: "https://example.com/b"}
+	a2 := &ContentItem{ID: "a2", Link: "https://example.com/a/"}
+	c2 := &ContentItem{ID: "c2", Link: "https://example.com/c"}
+	preferred := &switchableContentProvider{content: staticContentProvider{a, b}}
+	clock := newFakeClock()
+	cacher := newTestCacher(t, map[Provider]ProviderConfig{
+		Provider1: {expiration: time.Minute, length: 10, client: preferred},
+		Provider2: {expiration: time.Hour, length: 10, client: staticContentProvider{a2, c2}},
+	}, WithClock(clock), WithDeduplicator(NewDeduplicator(DedupSettings{})))
+	cacher.Start()
+	<-cacher.Ready()
+	defer cacher.Stop()
+	clock.BlockUntil(2)
+
+	t.Run("the duplicates are dropped, the indexes are contiguous", func(t *testing.T) {
+		state := cacher.GetState()
+		assert.Equal(t, 2, state.Available(Provider1))
+		assert.Equal(t, 1, state.Available(Provider2))
+		assert.Equal(t, c2, state.ContentItem(ContentAddress{Provider: Provider2, Index: 0}))
+	})
+	t.Run("the failing provider does not take the items", func(t *testing.T) {
+		preferred.setFail(true)
+		clock.Advance(time.Minute)
+		clock.BlockUntil(2)
+		state := cacher.GetState()
+		assert.True(t, state.Fails(Provider1))
+		assert.Equal(t, 2, state.Available(Provider2))
+		assert.Equal(t, a2, state.ContentItem(ContentAddress{Provider: Provider2, Index: 0}))
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// ContentEnvelope is the body of the v2 content endpoint, the page of the content items with the pagination metadata.
+type ContentEnvelope struct {
+	Items          []*ContentItem `json:"items"`
+	Offset         int            `json:"offset"`
+	CountRequested int            `json:"count_requested"`
+	CountReturned  int            `json:"count_returned"`
+	// NextOffset and NextCursor point to the next page, they are left out if the page is the last one.
+	NextOffset *int   `json:"next_offset,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	// TruncatedReason tells why the page is cut short, e.g. "provider_failed:2", it is left out if the page is not.
+	TruncatedReason string `json:"truncated_reason,omitempty"`
+	// FailurePolicies are the failure policies applied to the slots of the page which could not be served,
+	// e.g. "skip" tells the items have moved up over the slot of the failing provider.
+	FailurePolicies []FailurePolicy `json:"failure_policies"`
+	// Providers are the providers which items the page has.
+	Providers []Provider `json:"providers"`
+}
+
+// makeContentEnvelope wraps the page of the requested count in the envelope.
+func makeContentEnvelope(page ContentPage, limit int) ContentEnvelope {
+	envelope := ContentEnvelope{
+		Items:           page.Items,
+		Offset:          page.Offset,
+		CountRequested:  limit,
+		CountReturned:   len(page.Items),
+		NextCursor:      page.NextCursor,
+		TruncatedReason: page.TruncatedReason,
+		Providers:       page.Providers,
+		FailurePolicies: page.Policies(),
+	}
+	if page.NextCursor != "" {
+		next := page.Offset + len(page.Items)
+		envelope.NextOffset = &next
+	}
+	if envelope.Providers == nil {
+		envelope.Providers = []Provider{}
+	}
+	if envelope.FailurePolicies == nil {
+		envelope.FailurePolicies = []FailurePolicy{}
+	}
+	return envelope
+}
+
+// serveContentV2 serves the page of the content items in the envelope.
+func (a App) serveContentV2(w http.ResponseWriter, req *http.Request) {
+	page, limit, err := a.contentPage(req)
+	if err != nil {
+		writeErrorResponse(w, err)
+		return
+	}
+	bb, err := json.Marshal(makeContentEnvelope(page, limit))
+	if err != nil {
+		writeErrorResponse(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(bb); err != nil {
+		log.Println("error when trying to write data to HTTP response: " + err.Error())
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentEnvelope(t *testing.T) {
+	fallback := Provider3
+	state := &inMemoryState{
+		content: map[Provider][]*ContentItem{
+			Provider1: {{ID: "1-0"}, {ID: "1-1"}, {ID: "1-2"}},
+			Provider3: {{ID: "3-0"}, {ID: "3-1"}, {ID: "3-2"}},
+		},
+		failing: map[Provider]bool{Provider2: true},
+	}
+	serve := func(t *testing.T, mix ContentMix, target string) ContentEnvelope {
+		app := App{Service: MakeService(testCacher{state: state}, MakeConfiguredSequencer(mix))}
+		response := httptest.NewRecorder()
+		app.ServeHTTP(response, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "application/json", response.Header().Get("Content-Type"))
+		var envelope ContentEnvelope
+		assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &envelope), response.Body.String())
+		return envelope
+	}
+
+	t.Run("the page truncated by the failed provider", func(t *testing.T) {
+		envelope := serve(t, ContentMix{{Type: Provider1}, {Type: Provider2, Fallback: &fallback}, {Type: Provider2}},
+			"/v2/content?count=5")
+		assert.Equal(t, []string{"1-0", "3-0"}, envelopeIDs(envelope))
+		assert.Equal(t, 0, envelope.Offset)
+		assert.Equal(t, 5, envelope.CountRequested)
+		assert.Equal(t, 2, envelope.CountReturned)
+		assert.Nil(t, envelope.NextOffset)
+		assert.Empty(t, envelope.NextCursor)
+		assert.Equal(t, "provider_failed:2", envelope.TruncatedReason)
+		assert.Equal(t, []Provider{Provider1, Provider3}, envelope.Providers)
+		assert.Equal(t, []FailurePolicy{PolicyTruncate}, envelope.FailurePolicies)
+	})
+	t.Run("the skipped slots are reported", func(t *testing.T) {
+		envelope := serve(t, ContentMix{{Type: Provider1}, {Type: Provider2, OnFailure: PolicySkip}}, "/v2/content?count=2")
+		assert.Equal(t, []string{"1-0", "1-1"}, envelopeIDs(envelope))
+		assert.Empty(t, envelope.TruncatedReason)
+		assert.Equal(t, []FailurePolicy{PolicySkip}, envelope.FailurePolicies)
+	})
+	t.Run("the full page points to the next one", func(t *testing.T) {
+		mix := ContentMix{{Type: Provider1}, {Type: Provider3}}
+		envelope := serve(t, mix, "/v2/content?count=2&offset=2")
+		assert.Equal(t, []string{"1-1", "3-1"}, envelopeIDs(envelope))
+		assert.Equal(t, 2, envelope.Offset)
+		if assert.NotNil(t, envelope.NextOffset) {
+			assert.Equal(t, 4, *envelope.NextOffset)
+		}
+		assert.Empty(t, envelope.TruncatedReason)
+		assert.Equal(t, []FailurePolicy{}, envelope.FailurePolicies)
+
+		next := serve(t, mix, "/v2/content?count=2&cursor="+envelope.NextCursor)
+		assert.Equal(t, []string{"1-2", "3-2"}, envelopeIDs(next))
+		assert.Equal(t, 4, next.Offset)
+		if assert.NotNil(t, next.NextOffset) {
+			assert.Equal(t, 6, *next.NextOffset)
+		}
+
+		last := serve(t, mix, "/v2/content?count=2&offset=6")
+		assert.Empty(t, last.Items)
+		assert.Nil(t, last.NextOffset)
+		assert.Empty(t, last.TruncatedReason)
+		assert.Equal(t, []Provider{}, last.Providers)
+	})
+}
+
+func envelopeIDs(envelope ContentEnvelope) (ids []string) {
+	for _, item := range envelope.Items {
+		ids = append(ids, item.ID)
+	}
+	return
+}
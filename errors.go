@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// requestIDHeader identifies the request in the logs and in the error responses. The ID passed by the client
+// is kept, otherwise a new one is generated.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits the ID passed by the client, the longer one is replaced.
+const maxRequestIDLength = 128
+
+// ErrorCode is the stable machine readable code of the error response.
+type ErrorCode string
+
+const (
+	// CodeInvalidParameters the input parameters of the request are not valid.
+	CodeInvalidParameters ErrorCode = "invalid_parameters"
+	// CodeCursorExpired the state of the cursor is not kept anymore, the listing should start over.
+	CodeCursorExpired ErrorCode = "cursor_expired"
+	// CodeNotFound there is no endpoint at the path.
+	CodeNotFound ErrorCode = "not_found"
+	// CodeMethodNotAllowed the endpoint does not accept the method.
+	CodeMethodNotAllowed ErrorCode = "method_not_allowed"
+	// CodeReloadFailed the configuration could not be reloaded, the application runs with the old one.
+	CodeReloadFailed ErrorCode = "reload_failed"
+	// CodeInternalError the request failed on the server side, the details are in the logs only.
+	CodeInternalError ErrorCode = "internal_error"
+)
+
+// ClientError is the error reported to the client as is, with its code and the status of the response.
+// Any other error is reported as the internal one without the details.
+type ClientError interface {
+	error
+	Code() ErrorCode
+	Status() int
+}
+
+// ValidationError is the invalid input of the request which is not bound to a single parameter.
+type ValidationError string
+
+func (ve ValidationError) Error() string {
+	return string(ve)
+}
+
+// Code returns CodeInvalidParameters.
+func (ve ValidationError) Code() ErrorCode {
+	return CodeInvalidParameters
+}
+
+// Status returns 400.
+func (ve ValidationError) Status() int {
+	return http.StatusBadRequest
+}
+
+// ParameterError is the invalid value of the request parameter, its code is "invalid_" followed by the parameter,
+// e.g. "invalid_count".
+type ParameterError struct {
+	Parameter string
+	Reason    string
+}
+
+func (pe ParameterError) Error() string {
+	return pe.Parameter + " " + pe.Reason
+}
+
+// Code returns the code of the parameter.
+func (pe ParameterError) Code() ErrorCode {
+	return ErrorCode("invalid_" + pe.Parameter)
+}
+
+// Status returns 400.
+func (pe ParameterError) Status() int {
+	return http.StatusBadRequest
+}
+
+// clientError is the ClientError with the fixed code and status. It is comparable, so errors.Is matches the values.
+type clientError struct {
+	code    ErrorCode
+	status  int
+	message string
+}
+
+func (ce clientError) Error() string {
+	return ce.message
+}
+
+func (ce clientError) Code() ErrorCode {
+	return ce.code
+}
+
+func (ce clientError) Status() int {
+	return ce.status
+}
+
+// errorEnvelope is the body of every error response.
+type errorEnvelope struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Code      ErrorCode `json:"code"`
+	Message   string    `json:"message"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// requestID returns the ID of the request passed by the client, or a new one.
+func requestID(req *http.Request) string {
+	if id := req.Header.Get(requestIDHeader); id != "" && len(id) <= maxRequestIDLength {
+		return id
+	}
+	bb := make([]byte, 8)
+	if _, err := rand.Read(bb); err != nil {
+		log.Print("cannot generate the request ID: " + err.Error())
+		return ""
+	}
+	return hex.EncodeToString(bb)
+}
+
+// writeErrorResponse writes the error in the JSON envelope with the request ID of the response.
+// The message of the ClientError is written as is, the other errors are logged and reported as internal.
+func writeErrorResponse(w http.ResponseWriter, err error) {
+	var ce ClientError
+	if !errors.As(err, &ce) {
+		log.Print("internal server error: " + err.Error())
+		ce = clientError{code: CodeInternalError, status: http.StatusInternalServerError, message: "internal server error"}
+	} else {
+		log.Printf("%s: %s", ce.Code(), err.Error())
+	}
+	bb, err := json.Marshal(errorEnvelope{Error: errorBody{
+		Code:      ce.Code(),
+		Message:   ce.Error(),
+		RequestID: w.Header().Get(requestIDHeader),
+	}})
+	if err != nil {
+		log.Print("cannot encode the error response: " + err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(ce.Status())
+	if _, err := w.Write(bb); err != nil {
+		log.Println("error when trying to write data to HTTP response: " + err.Error())
+	}
+}
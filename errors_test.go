@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorResponses(t *testing.T) {
+	app, stop := mustBootstrapApp(t)
+	defer stop()
+	serve := func(req *http.Request) (*httptest.ResponseRecorder, errorEnvelope) {
+		response := httptest.NewRecorder()
+		app.ServeHTTP(response, req)
+		var envelope errorEnvelope
+		assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &envelope), response.Body.String())
+		assert.Equal(t, "application/json", response.Header().Get("Content-Type"))
+		assert.Equal(t, response.Header().Get(requestIDHeader), envelope.Error.RequestID)
+		return response, envelope
+	}
+
+	for _, tc := range []struct {
+		target string
+		status int
+		code   ErrorCode
+	}{
+		{target: "/v1/content?count=x", status: http.StatusBadRequest, code: "invalid_count"},
+		{target: "/v1/content?count=-1", status: http.StatusBadRequest, code: "invalid_count"},
+		{target: "/v1/content?offset=-1", status: http.StatusBadRequest, code: "invalid_offset"},
+		{target: fmt.Sprintf("/v1/content?count=1&offset=%d", maxInt), status: http.StatusBadRequest, code: "invalid_offset"},
+		{target: "/v1/content?cursor=garbage!", status: http.StatusBadRequest, code: "invalid_cursor"},
+		{target: "/v1/content?cursor=" + Cursor{Version: 1}.String(), status: http.StatusGone, code: CodeCursorExpired},
+		{target: "/unknown", status: http.StatusNotFound, code: CodeNotFound},
+	} {
+		t.Run(tc.target, func(t *testing.T) {
+			response, envelope := serve(httptest.NewRequest(http.MethodGet, tc.target, nil))
+			assert.Equal(t, tc.status, response.Code)
+			assert.Equal(t, tc.code, envelope.Error.Code)
+			assert.NotEmpty(t, envelope.Error.Message)
+			assert.NotEmpty(t, envelope.Error.RequestID)
+		})
+	}
+	t.Run("the request ID of the client is kept", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, contentPath, nil)
+		req.Header.Set(requestIDHeader, "abc-123")
+		response, envelope := serve(req)
+		assert.Equal(t, http.StatusMethodNotAllowed, response.Code)
+		assert.Equal(t, CodeMethodNotAllowed, envelope.Error.Code)
+		assert.Equal(t, "abc-123", envelope.Error.RequestID)
+	})
+	t.Run("the internal error is not disclosed", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		writeErrorResponse(response, fmt.Errorf("wrapped: %w", errors.New("dial tcp 10.0.0.1:5432: refused")))
+		assert.Equal(t, http.StatusInternalServerError, response.Code)
+		assert.JSONEq(t, `{"error":{"code":"internal_error","message":"internal server error"}}`, response.Body.String())
+	})
+	t.Run("the wrapped client error keeps its code", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		writeErrorResponse(response, fmt.Errorf("page: %w", ErrCursorExpired))
+		assert.Equal(t, http.StatusGone, response.Code)
+		assert.Contains(t, response.Body.String(), `"code":"cursor_expired"`)
+	})
+}
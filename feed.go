@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultFeedItemTTL = time.Hour * 24
+
+// rssDateLayouts are the date layouts met in the RSS pubDate, RFC 822 with the variations.
+var rssDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	time.RFC822Z,
+	time.RFC822,
+	time.RFC3339,
+}
+
+// FeedSettings describes how the FeedClient reads the feed.
+type FeedSettings struct {
+	// ItemTTL is how long an item stays fresh after it was published or updated.
+	ItemTTL          Duration `json:"item_ttl,omitempty"`
+	Timeout          Duration `json:"timeout,omitempty"`
+	MaxResponseBytes int64    `json:"max_response_bytes,omitempty"`
+}
+
+func (fs FeedSettings) validate() (problems []string) {
+	if fs.ItemTTL < 0 {
+		problems = append(problems, "feed item_ttl should not be negative")
+	}
+	if fs.Timeout < 0 {
+		problems = append(problems, "feed timeout should not be negative")
+	}
+	if fs.MaxResponseBytes < 0 {
+		problems = append(problems, "feed max_response_bytes should not be negative")
+	}
+	return
+}
+
+// FeedClient is the Client fetching the content from an RSS 2.0 or Atom feed.
+// It keeps the ETag and Last-Modified of the last response, so the periodic refreshes are conditional GETs
+// and the feed not modified since is not downloaded again.
+type FeedClient struct {
+	source   Provider
+	endpoint string
+	settings FeedSettings
+	client   *http.Client
+
+	mu           sync.Mutex
+	etag         string
+	lastModified string
+	items        []feedItem
+}
+
+// feedItem is the entry of the feed, the expiry of its content item is stamped on every fetch,
+// so the item without the date stays fresh as long as the feed lists it.
+type feedItem struct {
+	item ContentItem
+	// published is when the entry was published, zero if the feed does not tell.
+	published time.Time
+}
+
+// NewFeedClient the constructor of the FeedClient, source is the provider the items are marked with.
+func NewFeedClient(source Provider, endpoint string, settings FeedSettings) (*FeedClient, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid endpoint %q: the scheme should be http or https", endpoint)
+	}
+	if problems := settings.validate(); len(problems) != 0 {
+		return nil, ConfigError(problems)
+	}
+	if settings.ItemTTL == 0 {
+		settings.ItemTTL = Duration(defaultFeedItemTTL)
+	}
+	if settings.Timeout == 0 {
+		settings.Timeout = Duration(defaultHTTPTimeout)
+	}
+	if settings.MaxResponseBytes == 0 {
+		settings.MaxResponseBytes = defaultHTTPMaxResponseBytes
+	}
+	return &FeedClient{
+		source:   source,
+		endpoint: endpoint,
+		settings: settings,
+		client:   &http.Client{Timeout: time.Duration(settings.Timeout)},
+	}, nil
+}
+
+// GetContent returns the first count items of the feed, the user IP is not used by the feeds.
+func (c *FeedClient) GetContent(userIP string, count int) ([]*ContentItem, error) {
+	return c.GetContentContext(context.Background(), userIP, count)
+}
+
+// GetContentContext returns the first count items of the feed, the request is cancelled with the context.
+func (c *FeedClient) GetContentContext(ctx context.Context, userIP string, count int) ([]*ContentItem, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml, text/xml")
+	if c.etag != "" {
+		req.Header.Set("If-None-Match", c.etag)
+	}
+	if c.lastModified != "" {
+		req.Header.Set("If-Modified-Since", c.lastModified)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified && c.items != nil:
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		bb, err := readLimited(resp.Body, c.settings.MaxResponseBytes)
+		if err != nil {
+			return nil, err
+		}
+		items, err := c.parse(bb)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse the feed %s: %w", c.endpoint, err)
+		}
+		c.items = items
+		c.etag = resp.Header.Get("ETag")
+		c.lastModified = resp.Header.Get("Last-Modified")
+	default:
+		return nil, &StatusError{URL: c.endpoint, StatusCode: resp.StatusCode}
+	}
+	entries := c.items
+	if len(entries) > count {
+		entries = entries[:count]
+	}
+	now := time.Now()
+	items := make([]*ContentItem, len(entries))
+	for i, entry := range entries {
+		items[i] = c.expiring(entry, now)
+	}
+	return items, nil
+}
+
+type rssFeed struct {
+	Items []rssItem `xml:"channel>item"`
+}
+
+type rssItem struct {
+	GUID        string `xml:"guid"`
+	Title       string `xml:"title"`
+	Description string `xml:"description"`
+	Link        string `xml:"link"`
+	PubDate     string `xml:"pubDate"`
+}
+
+type atomFeed struct {
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Summary   string     `xml:"summary"`
+	Links     []atomLink `xml:"link"`
+	Updated   string     `xml:"updated"`
+	Published string     `xml:"published"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+}
+
+// alternate returns the link to the entry itself.
+func (ae atomEntry) alternate() string {
+	for _, link := range ae.Links {
+		if link.Rel == "" || link.Rel == "alternate" {
+			return link.Href
+		}
+	}
+	return ""
+}
+
+// parse detects the format of the feed by its root element and maps the entries to the content items.
+// The date of the entry which cannot be parsed is logged and taken as missing, the rest of the feed is not lost over it.
+func (c *FeedClient) parse(bb []byte) ([]feedItem, error) {
+	var root struct {
+		XMLName xml.Name
+	}
+	if err := xml.Unmarshal(bb, &root); err != nil {
+		return nil, err
+	}
+	switch root.XMLName.Local {
+	case "rss":
+		var feed rssFeed
+		if err := xml.Unmarshal(bb, &feed); err != nil {
+			return nil, err
+		}
+		items := make([]feedItem, 0, len(feed.Items))
+		for _, ri := range feed.Items {
+			id := ri.GUID
+			if id == "" {
+				id = ri.Link
+			}
+			published, err := parseRSSDate(ri.PubDate)
+			if err != nil {
+				log.Printf("feed of the provider %q, item %q: %v, the item expires as the one without the date", c.source, id, err)
+			}
+			items = append(items, c.item(id, ri.Title, ri.Description, ri.Link, published))
+		}
+		return items, nil
+	case "feed":
+		var feed atomFeed
+		if err := xml.Unmarshal(bb, &feed); err != nil {
+			return nil, err
+		}
+		items := make([]feedItem, 0, len(feed.Entries))
+		for _, ae := range feed.Entries {
+			date := ae.Updated
+			if date == "" {
+				date = ae.Published
+			}
+			var updated time.Time
+			if date != "" {
+				var err error
+				if updated, err = time.Parse(time.RFC3339, strings.TrimSpace(date)); err != nil {
+					log.Printf("feed of the provider %q, entry %q: %v, the entry expires as the one without the date",
+						c.source, ae.ID, err)
+				}
+			}
+			items = append(items, c.item(ae.ID, ae.Title, ae.Summary, ae.alternate(), updated))
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unknown feed format with the root element %q", root.XMLName.Local)
+	}
+}
+
+// item builds the entry of the feed without the expiry.
+func (c *FeedClient) item(id, title, summary, link string, published time.Time) feedItem {
+	return feedItem{
+		item: ContentItem{
+			ID:      strings.TrimSpace(id),
+			Title:   strings.TrimSpace(title),
+			Source:  string(c.source),
+			Summary: strings.TrimSpace(summary),
+			Link:    strings.TrimSpace(link),
+		},
+		published: published,
+	}
+}
+
+// expiring returns the content item of the entry, which expires the item TTL after it was published,
+// or after it was fetched if the feed does not tell when it was published.
+func (c *FeedClient) expiring(entry feedItem, fetched time.Time) *ContentItem {
+	published := entry.published
+	if published.IsZero() {
+		published = fetched
+	}
+	item := entry.item
+	item.Expiry = published.Add(time.Duration(c.settings.ItemTTL))
+	return &item
+}
+
+func parseRSSDate(date string) (time.Time, error) {
+	date = strings.TrimSpace(date)
+	if date == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range rssDateLayouts {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown date format %q", date)
+}
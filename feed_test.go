@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const rssFeedXML = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+<channel>
+	<title>News</title>
+	<item>
+		<guid>rss-1</guid>
+		<title>First</title>
+		<description>First summary</description>
+		<link>https://example.com/1</link>
+		<pubDate>Thu, 24 Sep 2020 10:47:11 +0000</pubDate>
+	</item>
+	<item>
+		<title>Second</title>
+		<link>https://example.com/2</link>
+		<pubDate>Thu, 24 Sep 2020 09:47:11 GMT</pubDate>
+	</item>
+	<item>
+		<guid>rss-3</guid>
+		<title>Third</title>
+	</item>
+</channel>
+</rss>`
+
+const atomFeedXML = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+	<title>News</title>
+	<entry>
+		<id>urn:uuid:1</id>
+		<title>First</title>
+		<summary>First summary</summary>
+		<link rel="self" href="https://example.com/feed/1"/>
+		<link href="https://example.com/1"/>
+		<updated>2020-09-24T10:47:11Z</updated>
+	</entry>
+	<entry>
+		<id>urn:uuid:2</id>
+		<title>Second</title>
+		<link rel="alternate" href="https://example.com/2"/>
+		<published>2020-09-24T09:47:11Z</published>
+	</entry>
+</feed>`
+
+func TestFeedClient_GetContent(t *testing.T) {
+	t.Run("rss feed", func(t *testing.T) {
+		srv := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(rssFeedXML))
+		})
+		client, err := NewFeedClient(Provider1, srv.URL, FeedSettings{ItemTTL: Duration(time.Hour)})
+		assert.NoError(t, err)
+		items, err := client.GetContent("184.22.11.68", 2)
+		assert.NoError(t, err)
+		for _, item := range items {
+			item.Expiry = item.Expiry.UTC()
+		}
+		assert.Equal(t, []*ContentItem{
+			{
+				ID:      "rss-1",
+				Title:   "First",
+				Source:  "1",
+				Summary: "First summary",
+				Link:    "https://example.com/1",
+				Expiry:  time.Date(2020, 9, 24, 11, 47, 11, 0, time.UTC),
+			},
+			{
+				ID:     "https://example.com/2",
+				Title:  "Second",
+				Source: "1",
+				Link:   "https://example.com/2",
+				Expiry: time.Date(2020, 9, 24, 10, 47, 11, 0, time.UTC),
+			},
+		}, items)
+	})
+	t.Run("item without date is fresh from the fetch", func(t *testing.T) {
+		srv := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(rssFeedXML))
+		})
+		client, err := NewFeedClient(Provider1, srv.URL, FeedSettings{ItemTTL: Duration(time.Hour)})
+		assert.NoError(t, err)
+		items, err := client.GetContent("184.22.11.68", 10)
+		assert.NoError(t, err)
+		assert.Len(t, items, 3)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), items[2].Expiry, time.Minute)
+	})
+	t.Run("item with malformed date is fresh from the fetch", func(t *testing.T) {
+		for name, body := range map[string]string{
+			"rss": `<rss><channel><item><guid>1</guid><pubDate>yesterday</pubDate></item>` +
+				`<item><guid>2</guid><pubDate>Thu, 24 Sep 2020 10:47:11 GMT</pubDate></item></channel></rss>`,
+			"atom": `<feed xmlns="http://www.w3.org/2005/Atom"><entry><id>1</id><updated>yesterday</updated></entry>` +
+				`<entry><id>2</id><updated>2020-09-24T10:47:11Z</updated></entry></feed>`,
+		} {
+			b := body
+			t.Run(name, func(t *testing.T) {
+				srv := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+					_, _ = w.Write([]byte(b))
+				})
+				client, err := NewFeedClient(Provider1, srv.URL, FeedSettings{ItemTTL: Duration(time.Hour)})
+				assert.NoError(t, err)
+				items, err := client.GetContent("184.22.11.68", 10)
+				assert.NoError(t, err)
+				if assert.Len(t, items, 2) {
+					assert.WithinDuration(t, time.Now().Add(time.Hour), items[0].Expiry, time.Minute)
+					assert.Equal(t, time.Date(2020, 9, 24, 11, 47, 11, 0, time.UTC), items[1].Expiry.UTC())
+				}
+			})
+		}
+	})
+	t.Run("atom feed", func(t *testing.T) {
+		srv := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(atomFeedXML))
+		})
+		client, err := NewFeedClient(Provider2, srv.URL, FeedSettings{})
+		assert.NoError(t, err)
+		items, err := client.GetContent("184.22.11.68", 10)
+		assert.NoError(t, err)
+		assert.Equal(t, []*ContentItem{
+			{
+				ID:      "urn:uuid:1",
+				Title:   "First",
+				Source:  "2",
+				Summary: "First summary",
+				Link:    "https://example.com/1",
+				Expiry:  time.Date(2020, 9, 25, 10, 47, 11, 0, time.UTC),
+			},
+			{
+				ID:     "urn:uuid:2",
+				Title:  "Second",
+				Source: "2",
+				Link:   "https://example.com/2",
+				Expiry: time.Date(2020, 9, 25, 9, 47, 11, 0, time.UTC),
+			},
+		}, items)
+	})
+	t.Run("conditional get", func(t *testing.T) {
+		var downloads int32
+		srv := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("If-None-Match") == `"v1"` &&
+				r.Header.Get("If-Modified-Since") == "Thu, 24 Sep 2020 10:47:11 GMT" {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			atomic.AddInt32(&downloads, 1)
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Last-Modified", "Thu, 24 Sep 2020 10:47:11 GMT")
+			_, _ = w.Write([]byte(atomFeedXML))
+		})
+		client, err := NewFeedClient(Provider2, srv.URL, FeedSettings{})
+		assert.NoError(t, err)
+		items1, err := client.GetContent("184.22.11.68", 10)
+		assert.NoError(t, err)
+		items2, err := client.GetContent("184.22.11.68", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&downloads))
+		assert.Equal(t, items1[:1], items2)
+	})
+	t.Run("item without date stays fresh while the feed is not modified", func(t *testing.T) {
+		srv := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+			_, _ = w.Write([]byte(rssFeedXML))
+		})
+		client, err := NewFeedClient(Provider1, srv.URL, FeedSettings{ItemTTL: Duration(time.Millisecond * 100)})
+		assert.NoError(t, err)
+		items1, err := client.GetContent("184.22.11.68", 10)
+		assert.NoError(t, err)
+		time.Sleep(time.Millisecond * 150)
+		items2, err := client.GetContent("184.22.11.68", 10)
+		assert.NoError(t, err)
+		if assert.Len(t, items2, 3) {
+			assert.True(t, items2[2].Expiry.After(time.Now()), "the item is fresh from the last fetch")
+			assert.Equal(t, items1[0].Expiry, items2[0].Expiry, "the dated item keeps its expiry")
+		}
+	})
+	t.Run("non-2xx response", func(t *testing.T) {
+		srv := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		})
+		client, err := NewFeedClient(Provider1, srv.URL, FeedSettings{})
+		assert.NoError(t, err)
+		_, err = client.GetContent("184.22.11.68", 10)
+		var statusErr *StatusError
+		assert.True(t, errors.As(err, &statusErr))
+		assert.Equal(t, http.StatusNotFound, statusErr.StatusCode)
+	})
+	t.Run("malformed feeds", func(t *testing.T) {
+		for name, body := range map[string]string{
+			"not xml":        `{"items": []}`,
+			"unknown format": `<html><body/></html>`,
+		} {
+			b := body
+			t.Run(name, func(t *testing.T) {
+				srv := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+					_, _ = w.Write([]byte(b))
+				})
+				client, err := NewFeedClient(Provider1, srv.URL, FeedSettings{})
+				assert.NoError(t, err)
+				_, err = client.GetContent("184.22.11.68", 10)
+				assert.Error(t, err)
+			})
+		}
+	})
+}
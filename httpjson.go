@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHTTPTimeout          = time.Second * 5
+	defaultHTTPMaxResponseBytes = 1 << 20
+)
+
+// contentItemFields are the fields of the ContentItem which can be mapped from the upstream response.
+var contentItemFields = []string{"id", "title", "summary", "link", "expiry"}
+
+// HTTPJSONSettings describes how the HTTPJSONClient calls the upstream API and reads its response.
+type HTTPJSONSettings struct {
+	// UserIPParam and UserIPHeader are the query parameter and the header to pass the user IP with,
+	// the query parameter "user_ip" is used if neither is set.
+	UserIPParam  string `json:"user_ip_param,omitempty"`
+	UserIPHeader string `json:"user_ip_header,omitempty"`
+	// CountParam and CountHeader are the query parameter and the header to pass the count with,
+	// the query parameter "count" is used if neither is set.
+	CountParam  string `json:"count_param,omitempty"`
+	CountHeader string `json:"count_header,omitempty"`
+	// ItemsField is the dot separated path to the array of items in the response object,
+	// the response itself should be the array if it is empty.
+	ItemsField string `json:"items_field,omitempty"`
+	// Mapping maps the ContentItem fields (id, title, summary, link, expiry) to the dot separated paths
+	// of the upstream item fields, the not mapped fields are read from the fields with the same name.
+	Mapping          map[string]string `json:"mapping,omitempty"`
+	Timeout          Duration          `json:"timeout,omitempty"`
+	MaxResponseBytes int64             `json:"max_response_bytes,omitempty"`
+}
+
+func (hs HTTPJSONSettings) validate() (problems []string) {
+	for field := range hs.Mapping {
+		if !isContentItemField(field) {
+			problems = append(problems, fmt.Sprintf("unknown mapped field %q, expected one of %s",
+				field, strings.Join(contentItemFields, ", ")))
+		}
+	}
+	if hs.Timeout < 0 {
+		problems = append(problems, "http timeout should not be negative")
+	}
+	if hs.MaxResponseBytes < 0 {
+		problems = append(problems, "http max_response_bytes should not be negative")
+	}
+	return
+}
+
+func isContentItemField(field string) bool {
+	for _, f := range contentItemFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusError is returned when the upstream responds with a non-2xx status.
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (se *StatusError) Error() string {
+	return fmt.Sprintf("upstream %s responded with status %d %s", se.URL, se.StatusCode, http.StatusText(se.StatusCode))
+}
+
+// HTTPJSONClient is the Client fetching the content from an upstream HTTP API responding with JSON.
+type HTTPJSONClient struct {
+	source   Provider
+	endpoint *url.URL
+	settings HTTPJSONSettings
+	mapping  map[string]string
+	client   *http.Client
+}
+
+// NewHTTPJSONClient the constructor of the HTTPJSONClient, source is the provider the items are marked with.
+func NewHTTPJSONClient(source Provider, endpoint string, settings HTTPJSONSettings) (*HTTPJSONClient, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid endpoint %q: the scheme should be http or https", endpoint)
+	}
+	if problems := settings.validate(); len(problems) != 0 {
+		return nil, ConfigError(problems)
+	}
+	if settings.UserIPParam == "" && settings.UserIPHeader == "" {
+		settings.UserIPParam = "user_ip"
+	}
+	if settings.CountParam == "" && settings.CountHeader == "" {
+		settings.CountParam = "count"
+	}
+	if settings.Timeout == 0 {
+		settings.Timeout = Duration(defaultHTTPTimeout)
+	}
+	if settings.MaxResponseBytes == 0 {
+		settings.MaxResponseBytes = defaultHTTPMaxResponseBytes
+	}
+	mapping := make(map[string]string, len(contentItemFields))
+	for _, field := range contentItemFields {
+		mapping[field] = field
+	}
+	for field, path := range settings.Mapping {
+		mapping[field] = path
+	}
+	return &HTTPJSONClient{
+		source:   source,
+		endpoint: u,
+		settings: settings,
+		mapping:  mapping,
+		client:   &http.Client{Timeout: time.Duration(settings.Timeout)},
+	}, nil
+}
+
+// GetContent fetches up to count content items from the upstream.
+func (c *HTTPJSONClient) GetContent(userIP string, count int) ([]*ContentItem, error) {
+	return c.GetContentContext(context.Background(), userIP, count)
+}
+
+// GetContentContext fetches up to count content items from the upstream, the request is cancelled with the context.
+func (c *HTTPJSONClient) GetContentContext(ctx context.Context, userIP string, count int) ([]*ContentItem, error) {
+	req, err := c.newRequest(ctx, userIP, count)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &StatusError{URL: c.endpoint.String(), StatusCode: resp.StatusCode}
+	}
+	bb, err := readLimited(resp.Body, c.settings.MaxResponseBytes)
+	if err != nil {
+		return nil, err
+	}
+	items, err := c.decode(bb)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode the response of %s: %w", c.endpoint, err)
+	}
+	if len(items) > count {
+		items = items[:count]
+	}
+	return items, nil
+}
+
+func (c *HTTPJSONClient) newRequest(ctx context.Context, userIP string, count int) (*http.Request, error) {
+	u := *c.endpoint
+	query := u.Query()
+	if c.settings.UserIPParam != "" {
+		query.Set(c.settings.UserIPParam, userIP)
+	}
+	if c.settings.CountParam != "" {
+		query.Set(c.settings.CountParam, strconv.Itoa(count))
+	}
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.settings.UserIPHeader != "" {
+		req.Header.Set(c.settings.UserIPHeader, userIP)
+	}
+	if c.settings.CountHeader != "" {
+		req.Header.Set(c.settings.CountHeader, strconv.Itoa(count))
+	}
+	return req, nil
+}
+
+// readLimited reads the whole body failing if it is longer than max bytes.
+func readLimited(body io.Reader, max int64) ([]byte, error) {
+	bb, err := io.ReadAll(io.LimitReader(body, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(bb)) > max {
+		return nil, fmt.Errorf("response exceeds %d bytes", max)
+	}
+	return bb, nil
+}
+
+func (c *HTTPJSONClient) decode(bb []byte) ([]*ContentItem, error) {
+	decoder := json.NewDecoder(bytes.NewReader(bb))
+	decoder.UseNumber()
+	var body interface{}
+	if err := decoder.Decode(&body); err != nil {
+		return nil, err
+	}
+	if c.settings.ItemsField != "" {
+		body = lookupPath(body, c.settings.ItemsField)
+	}
+	rawItems, ok := body.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("items %q are not an array", c.settings.ItemsField)
+	}
+	items := make([]*ContentItem, 0, len(rawItems))
+	for i, rawItem := range rawItems {
+		item, err := c.mapItem(rawItem)
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %w", i, err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (c *HTTPJSONClient) mapItem(rawItem interface{}) (*ContentItem, error) {
+	if _, ok := rawItem.(map[string]interface{}); !ok {
+		return nil, errors.New("not an object")
+	}
+	item := &ContentItem{Source: string(c.source)}
+	for _, target := range []struct {
+		field string
+		value *string
+	}{
+		{"id", &item.ID},
+		{"title", &item.Title},
+		{"summary", &item.Summary},
+		{"link", &item.Link},
+	} {
+		value, err := stringValue(lookupPath(rawItem, c.mapping[target.field]))
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", c.mapping[target.field], err)
+		}
+		*target.value = value
+	}
+	expiry, err := timeValue(lookupPath(rawItem, c.mapping["expiry"]))
+	if err != nil {
+		return nil, fmt.Errorf("field %q: %w", c.mapping["expiry"], err)
+	}
+	item.Expiry = expiry
+	return item, nil
+}
+
+// lookupPath returns the value at the dot separated path of the decoded JSON, or nil if there is no such value.
+func lookupPath(value interface{}, path string) interface{} {
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+func stringValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	default:
+		return "", fmt.Errorf("expected a string, got %T", value)
+	}
+}
+
+// timeValue reads the time either from the RFC 3339 string or from the number of seconds since the Unix epoch.
+func timeValue(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case nil:
+		return time.Time{}, nil
+	case string:
+		return time.Parse(time.RFC3339, v)
+	case json.Number:
+		seconds, err := v.Int64()
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(seconds, 0), nil
+	default:
+		return time.Time{}, fmt.Errorf("expected a time, got %T", value)
+	}
+}
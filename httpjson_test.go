@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newUpstream(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHTTPJSONClient_GetContent(t *testing.T) {
+	t.Run("fields are mapped", func(t *testing.T) {
+		srv := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "184.22.11.68", r.URL.Query().Get("ip"))
+			assert.Equal(t, "2", r.Header.Get("X-Count"))
+			assert.Equal(t, "en", r.URL.Query().Get("lang"))
+			_, _ = w.Write([]byte(`{"data": {"articles": [
+				{"id": 17, "headline": "first", "meta": {"url": "https://example.com/1"}, "expires": "2020-09-24T11:47:11Z"},
+				{"id": "18", "headline": "second", "description": "summary", "expires": 1600948031},
+				{"id": "19", "headline": "third"}
+			]}}`))
+		})
+		client, err := NewHTTPJSONClient(Provider1, srv.URL+"?lang=en", HTTPJSONSettings{
+			UserIPParam: "ip",
+			CountHeader: "X-Count",
+			ItemsField:  "data.articles",
+			Mapping: map[string]string{
+				"title":   "headline",
+				"link":    "meta.url",
+				"summary": "description",
+				"expiry":  "expires",
+			},
+		})
+		assert.NoError(t, err)
+		items, err := client.GetContent("184.22.11.68", 2)
+		assert.NoError(t, err)
+		assert.Equal(t, []*ContentItem{
+			{
+				ID:     "17",
+				Title:  "first",
+				Source: "1",
+				Link:   "https://example.com/1",
+				Expiry: time.Date(2020, 9, 24, 11, 47, 11, 0, time.UTC),
+			},
+			{
+				ID:      "18",
+				Title:   "second",
+				Source:  "1",
+				Summary: "summary",
+				Expiry:  time.Unix(1600948031, 0),
+			},
+		}, items)
+	})
+	t.Run("default parameters and fields", func(t *testing.T) {
+		srv := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "184.22.11.68", r.URL.Query().Get("user_ip"))
+			assert.Equal(t, "10", r.URL.Query().Get("count"))
+			_, _ = w.Write([]byte(`[{"id": "1", "title": "title", "link": "https://example.com"}]`))
+		})
+		client, err := NewHTTPJSONClient(Provider2, srv.URL, HTTPJSONSettings{})
+		assert.NoError(t, err)
+		items, err := client.GetContent("184.22.11.68", 10)
+		assert.NoError(t, err)
+		assert.Equal(t, []*ContentItem{{ID: "1", Title: "title", Source: "2", Link: "https://example.com"}}, items)
+	})
+	t.Run("non-2xx response", func(t *testing.T) {
+		srv := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+		client, err := NewHTTPJSONClient(Provider1, srv.URL, HTTPJSONSettings{})
+		assert.NoError(t, err)
+		_, err = client.GetContent("184.22.11.68", 10)
+		var statusErr *StatusError
+		assert.True(t, errors.As(err, &statusErr))
+		assert.Equal(t, http.StatusServiceUnavailable, statusErr.StatusCode)
+		assert.Contains(t, err.Error(), "503 Service Unavailable")
+	})
+	t.Run("response too large", func(t *testing.T) {
+		srv := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`[{"title": "` + strings.Repeat("a", 100) + `"}]`))
+		})
+		client, err := NewHTTPJSONClient(Provider1, srv.URL, HTTPJSONSettings{MaxResponseBytes: 50})
+		assert.NoError(t, err)
+		_, err = client.GetContent("184.22.11.68", 10)
+		assert.EqualError(t, err, "response exceeds 50 bytes")
+	})
+	t.Run("timeout", func(t *testing.T) {
+		release := make(chan struct{})
+		srv := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		})
+		defer close(release)
+		client, err := NewHTTPJSONClient(Provider1, srv.URL, HTTPJSONSettings{Timeout: Duration(time.Millisecond * 50)})
+		assert.NoError(t, err)
+		_, err = client.GetContent("184.22.11.68", 10)
+		assert.Error(t, err)
+	})
+	t.Run("cancelled with the context", func(t *testing.T) {
+		release := make(chan struct{})
+		srv := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		})
+		defer close(release)
+		client, err := NewHTTPJSONClient(Provider1, srv.URL, HTTPJSONSettings{})
+		assert.NoError(t, err)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer cancel()
+		_, err = client.GetContentContext(ctx, "184.22.11.68", 10)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	})
+	t.Run("malformed responses", func(t *testing.T) {
+		for name, body := range map[string]string{
+			"not json":         `<html>`,
+			"not an array":     `{"items": []}`,
+			"not an object":    `["item"]`,
+			"not a string":     `[{"title": {"text": "title"}}]`,
+			"not a valid time": `[{"expiry": "tomorrow"}]`,
+		} {
+			b := body
+			t.Run(name, func(t *testing.T) {
+				srv := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+					_, _ = w.Write([]byte(b))
+				})
+				client, err := NewHTTPJSONClient(Provider1, srv.URL, HTTPJSONSettings{})
+				assert.NoError(t, err)
+				_, err = client.GetContent("184.22.11.68", 10)
+				assert.Error(t, err)
+			})
+		}
+	})
+}
+
+func TestNewHTTPJSONClient(t *testing.T) {
+	_, err := NewHTTPJSONClient(Provider1, "ftp://example.com", HTTPJSONSettings{})
+	assert.Error(t, err)
+	_, err = NewHTTPJSONClient(Provider1, "https://example.com", HTTPJSONSettings{
+		Mapping: map[string]string{"headline": "title"},
+	})
+	assert.Error(t, err)
+}
@@ -7,23 +7,49 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
-	"time"
+	"syscall"
 )
 
 var (
-	addr = flag.String("addr", "127.0.0.1:8080", "the TCP address for the server to listen on, in the form 'host:port'")
+	addr       = flag.String("addr", "127.0.0.1:8080", "the TCP address for the server to listen on, in the form 'host:port', overrides the configuration file")
+	configPath = flag.String("config", "", "the path to the JSON configuration file, the built-in configuration is used if empty")
 )
 
 func main() {
-	log.Printf("initalising server on %s", *addr)
+	flag.Parse()
 
-	app, stopApp := bootstrapApp()
+	config, err := loadConfig()
+	if err != nil {
+		log.Fatalf("cannot load the configuration: %v", err)
+	}
+
+	log.Printf("initalising server on %s", config.Listen)
+
+	app, stopApp, err := bootstrapApp(config, loadConfig)
+	if err != nil {
+		log.Fatalf("cannot bootstrap the application: %v", err)
+	}
 
 	srv := http.Server{
-		Addr:    *addr,
+		Addr:    config.Listen,
 		Handler: app,
 	}
 
+	// the admin endpoints are served apart from the content, on the address which is not public
+	var adminSrv *http.Server
+	if config.AdminListen != "" {
+		log.Printf("serving the admin endpoints on %s", config.AdminListen)
+		adminSrv = &http.Server{
+			Addr:    config.AdminListen,
+			Handler: app.AdminHandler(),
+		}
+		go func() {
+			if err := adminSrv.ListenAndServe(); err != http.ErrServerClosed {
+				log.Printf("admin HTTP server ListenAndServe: %v", err)
+			}
+		}()
+	}
+
 	idleConnsClosed := make(chan struct{})
 	go func() {
 		sigint := make(chan os.Signal, 1)
@@ -31,6 +57,11 @@ func main() {
 		<-sigint
 
 		// We received an interrupt signal, shut down.
+		if adminSrv != nil {
+			if err := adminSrv.Shutdown(context.Background()); err != nil {
+				log.Printf("admin HTTP server Shutdown: %v", err)
+			}
+		}
 		if err := srv.Shutdown(context.Background()); err != nil {
 			// Error from closing listeners, or context timeout:
 			log.Printf("HTTP server Shutdown: %v", err)
@@ -38,6 +69,18 @@ func main() {
 		close(idleConnsClosed)
 	}()
 
+	go func() {
+		sighup := make(chan os.Signal, 1)
+		signal.Notify(sighup, syscall.SIGHUP)
+		for range sighup {
+			if err := app.Reloader.Reload(); err != nil {
+				log.Printf("cannot reload the configuration: %v", err)
+				continue
+			}
+			log.Print("configuration reloaded")
+		}
+	}()
+
 	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
 		stopApp()
 		// Error starting or closing listener:
@@ -48,34 +91,52 @@ func main() {
 	<-idleConnsClosed
 }
 
-func bootstrapApp() (app App, stop func()) {
-	cacher := NewTimeExpirationCacher(map[Provider]ProviderConfig{
-		Provider1: {
-			expiration: time.Minute * 10,
-			length:     300,
-			userIp:     "184.22.11.68",
-			client:     SampleContentProvider{Provider1},
-		},
-		Provider2: {
-			expiration: time.Minute * 5,
-			length:     100,
-			userIp:     "184.22.11.68",
-			client:     SampleContentProvider{Provider2},
-		},
-		Provider3: {
-			expiration: time.Minute * 20,
-			length:     100,
-			userIp:     "184.22.11.68",
-			client:     SampleContentProvider{Provider3},
-		},
+// loadConfig loads the configuration file given in the flags, or returns the built-in configuration.
+// The listen address given explicitly in the flags wins over the one from the configuration.
+func loadConfig() (config AppConfig, err error) {
+	config = DefaultAppConfig()
+	if *configPath != "" {
+		config, err = LoadAppConfig(*configPath)
+		if err != nil {
+			return
+		}
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "addr" {
+			config.Listen = *addr
+		}
 	})
-	// wait until we feed the data before starting the app
+	return
+}
+
+// bootstrapApp builds and starts the application with the given configuration,
+// reload returns the configuration to apply on the reload, the reload is disabled if it is nil.
+func bootstrapApp(config AppConfig, reload func() (AppConfig, error)) (app App, stop func(), err error) {
+	providerConfigs, err := config.ProviderConfigs()
+	if err != nil {
+		return App{}, nil, err
+	}
+	metrics := NewMetrics()
+	cacher, err := NewTimeExpirationCacher(providerConfigs, append(config.cacherOptions(), WithMetrics(metrics))...)
+	if err != nil {
+		return App{}, nil, err
+	}
+	// the providers are loaded in the background, the app tells if it is ready on the readiness endpoint
 	cacher.Start()
 
-	sequencer := MakeConfiguredSequencer(DefaultConfig)
+	sequencer := NewSwappableSequencer(config.sequencer())
 
-	service := MakeService(cacher, sequencer)
+	service := MakeService(cacher, sequencer, WithServiceMetrics(metrics))
 
-	app = App{service}
-	return app, func() { cacher.Stop() }
+	app = App{
+		Service:          service,
+		Readiness:        cacher,
+		Status:           cacher,
+		Metrics:          metrics,
+		DisableRootAlias: config.DisableRootAlias,
+	}
+	if reload != nil {
+		app.Reloader = NewReloader(config, reload, cacher, sequencer)
+	}
+	return app, func() { cacher.Stop() }, nil
 }
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const metricsPath = "/metrics"
+
+// The types of the metrics.
+const (
+	counterMetric   = "counter"
+	gaugeMetric     = "gauge"
+	histogramMetric = "histogram"
+)
+
+// The outcomes of the provider refresh.
+const (
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+)
+
+// labelValueEscaper escapes the label values as the exposition format requires.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+var (
+	latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+	updateBuckets  = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+)
+
+// Metrics collects the metrics of the application and serves them in the Prometheus text exposition format.
+// The methods recording the metrics do nothing on the nil Metrics, so the metrics can be left out.
+type Metrics struct {
+	requests       *metricFamily
+	contentLatency *metricFamily
+	shortPages     *metricFamily
+	updateDuration *metricFamily
+	items          *metricFamily
+	substitutions  *metricFamily
+}
+
+// NewMetrics the constructor of the Metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests: newMetricFamily("sliide_http_requests_total", counterMetric,
+			"The number of the HTTP requests served by the status code.", "code"),
+		contentLatency: newHistogramFamily("sliide_content_items_duration_seconds",
+			"The time to make the page of the content items.", latencyBuckets),
+		shortPages: newMetricFamily("sliide_short_pages_total", counterMetric,
+			"The number of the pages cut short by the slot of the failing provider.", "provider"),
+		updateDuration: newHistogramFamily("sliide_provider_update_duration_seconds",
+			"The time to refresh the content of the provider by the outcome.", updateBuckets, "provider", "outcome"),
+		items: newMetricFamily("sliide_provider_items", gaugeMetric,
+			"The number of the items cached for the provider.", "provider"),
+		substitutions: newMetricFamily("sliide_fallback_substitutions_total", counterMetric,
+			"The number of the slots of the provider served by the fallback.", "provider", "fallback"),
+	}
+}
+
+func (m *Metrics) families() []*metricFamily {
+	return []*metricFamily{m.requests, m.contentLatency, m.shortPages, m.updateDuration, m.items, m.substitutions}
+}
+
+func (m *Metrics) observeRequest(code int) {
+	if m != nil {
+		m.requests.add(1, strconv.Itoa(code))
+	}
+}
+
+// observePage records the time the page took, the slot of the failing provider the page was cut short at
+// and the fallback substitutions. The page cut short by the provider which has run out of the items is not counted.
+func (m *Metrics) observePage(d time.Duration, page Page, state FailsState) {
+	if m == nil {
+		return
+	}
+	m.contentLatency.observe(d.Seconds())
+	for _, failure := range page.Failures {
+		if failure.Policy == PolicyTruncate && state.Fails(failure.Provider) {
+			m.shortPages.add(1, string(failure.Provider))
+		}
+	}
+	for _, substitution := range page.Substitutions {
+		m.substitutions.add(1, string(substitution.Provider), string(substitution.Fallback))
+	}
+}
+
+func (m *Metrics) observeUpdate(p Provider, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	outcome := outcomeSuccess
+	if err != nil {
+		outcome = outcomeFailure
+	}
+	m.updateDuration.observe(d.Seconds(), string(p), outcome)
+}
+
+func (m *Metrics) setItems(p Provider, items int) {
+	if m != nil {
+		m.items.set(float64(items), string(p))
+	}
+}
+
+func (m *Metrics) deleteItems(p Provider) {
+	if m != nil {
+		m.items.delete(string(p))
+	}
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	bw := bufio.NewWriter(w)
+	for _, family := range m.families() {
+		family.write(bw)
+	}
+	if err := bw.Flush(); err != nil {
+		log.Println("error when trying to write data to HTTP response: " + err.Error())
+	}
+}
+
+// metricFamily is the metric with all its label values.
+type metricFamily struct {
+	name, help, kind string
+	labels           []string
+	// buckets are the upper bounds of the buckets of the histogram.
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*series
+}
+
+// series is the value of the metric for the label values.
+type series struct {
+	labelValues []string
+	// value is the value of the counter or the gauge.
+	value float64
+	// counts are the numbers of the observations in every bucket of the histogram, not cumulative.
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newMetricFamily(name, kind, help string, labels ...string) *metricFamily {
+	return &metricFamily{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: make(map[string]*series),
+	}
+}
+
+func newHistogramFamily(name, help string, buckets []float64, labels ...string) *metricFamily {
+	mf := newMetricFamily(name, histogramMetric, help, labels...)
+	mf.buckets = buckets
+	return mf
+}
+
+// get returns the series of the label values, creating it if needed. The lock should be held.
+func (mf *metricFamily) get(labelValues []string) *series {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := mf.series[key]
+	if !ok {
+		s = &series{labelValues: labelValues, counts: make([]uint64, len(mf.buckets))}
+		mf.series[key] = s
+	}
+	return s
+}
+
+func (mf *metricFamily) add(v float64, labelValues ...string) {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	mf.get(labelValues).value += v
+}
+
+func (mf *metricFamily) set(v float64, labelValues ...string) {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	mf.get(labelValues).value = v
+}
+
+func (mf *metricFamily) observe(v float64, labelValues ...string) {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	s := mf.get(labelValues)
+	for i, bound := range mf.buckets {
+		if v <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (mf *metricFamily) delete(labelValues ...string) {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	delete(mf.series, strings.Join(labelValues, "\xff"))
+}
+
+// write writes the family in the text exposition format, the series sorted by the label values.
+func (mf *metricFamily) write(w io.Writer) {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", mf.name, mf.help, mf.name, mf.kind)
+	keys := make([]string, 0, len(mf.series))
+	for key := range mf.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := mf.series[key]
+		if mf.kind != histogramMetric {
+			fmt.Fprintf(w, "%s%s %s\n", mf.name, mf.labelPairs(s.labelValues), formatValue(s.value))
+			continue
+		}
+		cumulative := uint64(0)
+		for i, bound := range mf.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", mf.name, mf.labelPairs(s.labelValues, "le", formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", mf.name, mf.labelPairs(s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", mf.name, mf.labelPairs(s.labelValues), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", mf.name, mf.labelPairs(s.labelValues), s.count)
+	}
+}
+
+// labelPairs formats the labels with the values, and the extra label and value if given, e.g. {provider="1",le="0.5"}.
+func (mf *metricFamily) labelPairs(labelValues []string, extra ...string) string {
+	pairs := make([]string, 0, len(labelValues)+1)
+	for i, value := range labelValues {
+		pairs = append(pairs, mf.labels[i]+`="`+labelValueEscaper.Replace(value)+`"`)
+	}
+	if len(extra) == 2 {
+		pairs = append(pairs, extra[0]+`="`+extra[1]+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
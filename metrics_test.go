@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricFamily_write(t *testing.T) {
+	t.Run("counter", func(t *testing.T) {
+		mf := newMetricFamily("requests_total", counterMetric, "The requests.", "code", "path")
+		mf.add(1, "200", "/")
+		mf.add(2, "200", "/")
+		mf.add(1, "404", `a"b\c`+"\n")
+		var buf bytes.Buffer
+		mf.write(&buf)
+		assert.Equal(t, `# HELP requests_total The requests.
+# TYPE requests_total counter
+requests_total{code="200",path="/"} 3
+requests_total{code="404",path="a\"b\\c\n"} 1
+`, buf.String())
+	})
+	t.Run("histogram", func(t *testing.T) {
+		mf := newHistogramFamily("duration_seconds", "The duration.", []float64{0.1, 1}, "provider")
+		mf.observe(0.05, "1")
+		mf.observe(0.5, "1")
+		mf.observe(5, "1")
+		var buf bytes.Buffer
+		mf.write(&buf)
+		assert.Equal(t, `# HELP duration_seconds The duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{provider="1",le="0.1"} 1
+duration_seconds_bucket{provider="1",le="1"} 2
+duration_seconds_bucket{provider="1",le="+Inf"} 3
+duration_seconds_sum{provider="1"} 5.55
+duration_seconds_count{provider="1"} 3
+`, buf.String())
+	})
+	t.Run("gauge is deleted", func(t *testing.T) {
+		mf := newMetricFamily("items", gaugeMetric, "The items.", "provider")
+		mf.set(10, "1")
+		mf.set(5, "1")
+		mf.set(3, "2")
+		mf.delete("2")
+		var buf bytes.Buffer
+		mf.write(&buf)
+		assert.Equal(t, "# HELP items The items.\n# TYPE items gauge\nitems{provider=\"1\"} 5\n", buf.String())
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	t.Run("nil metrics record nothing", func(t *testing.T) {
+		var metrics *Metrics
+		metrics.observeRequest(http.StatusOK)
+		metrics.observePage(time.Second, Page{}, testFailsState{})
+		metrics.observeUpdate(Provider1, time.Second, errors.New("network error"))
+		metrics.setItems(Provider1, 10)
+		metrics.deleteItems(Provider1)
+	})
+	t.Run("endpoint", func(t *testing.T) {
+		metrics := NewMetrics()
+		cacher := newTestCacher(t, map[Provider]ProviderConfig{
+			Provider1: {expiration: time.Minute, length: 10, client: failedContentProvider{}},
+			Provider2: {expiration: time.Minute, length: 10, client: SampleContentProvider{Provider2}},
+		}, WithMetrics(metrics))
+		cacher.Start()
+		defer cacher.Stop()
+		<-cacher.Ready()
+		app := App{
+			Service: MakeService(cacher, MakeConfiguredSequencer(ContentMix{config1}), WithServiceMetrics(metrics)),
+			Metrics: metrics,
+		}
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?count=3", nil))
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?count=-1", nil))
+
+		response := httptest.NewRecorder()
+		app.ServeHTTP(response, httptest.NewRequest(http.MethodGet, metricsPath, nil))
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", response.Header().Get("Content-Type"))
+		body := response.Body.String()
+		for _, line := range []string{
+			`sliide_http_requests_total{code="200"} 1`,
+			`sliide_http_requests_total{code="400"} 1`,
+			`sliide_content_items_duration_seconds_count 1`,
+			`sliide_fallback_substitutions_total{provider="1",fallback="2"} 3`,
+			`sliide_provider_update_duration_seconds_count{provider="1",outcome="failure"} 1`,
+			`sliide_provider_update_duration_seconds_count{provider="2",outcome="success"} 1`,
+			`sliide_provider_items{provider="2"} 10`,
+		} {
+			assert.Contains(t, body, line+"\n")
+		}
+	})
+	t.Run("short pages of the failing providers only", func(t *testing.T) {
+		metrics := NewMetrics()
+		truncated := []SlotFailure{{Provider: Provider1, Policy: PolicyTruncate}}
+		metrics.observePage(time.Millisecond, Page{Failures: truncated}, testFailsState{available: map[Provider]int{Provider1: 0}})
+		metrics.observePage(time.Millisecond, Page{Failures: truncated}, testFailsState{fails: map[Provider]bool{Provider1: true}})
+		var buf bytes.Buffer
+		metrics.shortPages.write(&buf)
+		assert.Contains(t, buf.String(), `sliide_short_pages_total{provider="1"} 1`+"\n")
+	})
+}
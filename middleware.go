@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// ClientMiddleware decorates the Client with the additional behaviour, e.g. the resilience logic.
+// The decorated client supports the context, and passes it to the wrapped one.
+type ClientMiddleware func(Client) Client
+
+// Chain wraps the client with the middlewares, the first middleware is the outermost one.
+func Chain(client Client, middlewares ...ClientMiddleware) Client {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		client = middlewares[i](client)
+	}
+	return client
+}
+
+// clientFunc adapts the function to both Client and ContextClient.
+type clientFunc func(ctx context.Context, userIP string, count int) ([]*ContentItem, error)
+
+func (f clientFunc) GetContent(userIP string, count int) ([]*ContentItem, error) {
+	return f(context.Background(), userIP, count)
+}
+
+func (f clientFunc) GetContentContext(ctx context.Context, userIP string, count int) ([]*ContentItem, error) {
+	return f(ctx, userIP, count)
+}
+
+// RetryMiddleware retries the failed fetch up to policy.MaxAttempts times, waiting for the policy backoff between the attempts.
+// It stops retrying when the context is done, or when the circuit breaker is open.
+func RetryMiddleware(policy RetryPolicy, clock Clock) ClientMiddleware {
+	return func(next Client) Client {
+		client := AsContextClient(next)
+		return clientFunc(func(ctx context.Context, userIP string, count int) ([]*ContentItem, error) {
+			for failures := 0; ; failures++ {
+				content, err := client.GetContentContext(ctx, userIP, count)
+				if err == nil || errors.Is(err, ErrBreakerOpen) || ctx.Err() != nil {
+					return content, err
+				}
+				delay, ok := policy.backoff(failures + 1)
+				if !ok {
+					return nil, err
+				}
+				timer := clock.NewTimer(delay)
+				select {
+				case <-timer.C():
+				case <-ctx.Done():
+					timer.Stop()
+					return nil, err
+				}
+			}
+		})
+	}
+}
+
+// TimeoutMiddleware limits every fetch to the timeout.
+func TimeoutMiddleware(timeout time.Duration) ClientMiddleware {
+	return func(next Client) Client {
+		client := AsContextClient(next)
+		return clientFunc(func(ctx context.Context, userIP string, count int) ([]*ContentItem, error) {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			return client.GetContentContext(ctx, userIP, count)
+		})
+	}
+}
+
+// ErrBreakerOpen is returned without calling the client while the circuit breaker is open.
+var ErrBreakerOpen = errors.New("circuit breaker is open")
+
+// BreakerState is the state of the CircuitBreaker.
+type BreakerState string
+
+const (
+	// BreakerClosed the calls pass through.
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen the calls fail immediately with ErrBreakerOpen.
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen the calls pass through to probe if the client has recovered.
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// BreakerSettings configures the CircuitBreaker.
+type BreakerSettings struct {
+	// FailureThreshold is the number of consecutive failures opening the breaker.
+	FailureThreshold int `json:"failure_threshold"`
+	// OpenTimeout is how long the breaker stays open before letting the probe calls through.
+	OpenTimeout Duration `json:"open_timeout"`
+	// SuccessThreshold is the number of consecutive successful probes closing the breaker, 1 if not set.
+	SuccessThreshold int `json:"success_threshold,omitempty"`
+}
+
+func (bs BreakerSettings) validate() (problems []string) {
+	if bs.FailureThreshold <= 0 {
+		problems = append(problems, "breaker failure_threshold should be positive")
+	}
+	if bs.OpenTimeout <= 0 {
+		problems = append(problems, "breaker open_timeout should be positive")
+	}
+	if bs.SuccessThreshold < 0 {
+		problems = append(problems, "breaker success_threshold should not be negative")
+	}
+	return
+}
+
+// CircuitBreaker stops calling the failing client for a while, so it has time to recover.
+// It opens after FailureThreshold consecutive failures, becomes half-open after OpenTimeout,
+// and closes after SuccessThreshold consecutive successes in the half-open state, or opens again on a failure.
+type CircuitBreaker struct {
+	settings BreakerSettings
+	clock    Clock
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	successes int
+	openedAt  time.Time
+}
+
+// NewCircuitBreaker the constructor of the CircuitBreaker
+func NewCircuitBreaker(settings BreakerSettings, clock Clock) (*CircuitBreaker, error) {
+	if problems := settings.validate(); len(problems) != 0 {
+		return nil, ConfigError(problems)
+	}
+	if settings.SuccessThreshold == 0 {
+		settings.SuccessThreshold = 1
+	}
+	return &CircuitBreaker{
+		settings: settings,
+		clock:    clock,
+		state:    BreakerClosed,
+	}, nil
+}
+
+// State returns the current state of the breaker.
+func (cb *CircuitBreaker) State() BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.currentState()
+}
+
+func (cb *CircuitBreaker) currentState() BreakerState {
+	if cb.state == BreakerOpen && !cb.clock.Now().Before(cb.openedAt.Add(time.Duration(cb.settings.OpenTimeout))) {
+		cb.state = BreakerHalfOpen
+		cb.successes = 0
+	}
+	return cb.state
+}
+
+func (cb *CircuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.currentState() != BreakerOpen
+}
+
+func (cb *CircuitBreaker) record(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	state := cb.currentState()
+	if err == nil {
+		cb.failures = 0
+		if state == BreakerHalfOpen {
+			cb.successes++
+			if cb.successes >= cb.settings.SuccessThreshold {
+				cb.state = BreakerClosed
+			}
+		}
+		return
+	}
+	cb.failures++
+	if state == BreakerHalfOpen || cb.failures >= cb.settings.FailureThreshold {
+		cb.state = BreakerOpen
+		cb.openedAt = cb.clock.Now()
+	}
+}
+
+// Middleware returns the middleware calling the client through the breaker.
+func (cb *CircuitBreaker) Middleware() ClientMiddleware {
+	return func(next Client) Client {
+		client := AsContextClient(next)
+		return clientFunc(func(ctx context.Context, userIP string, count int) ([]*ContentItem, error) {
+			if !cb.allow() {
+				return nil, ErrBreakerOpen
+			}
+			content, err := client.GetContentContext(ctx, userIP, count)
+			if ctx.Err() == nil {
+				cb.record(err)
+			}
+			return content, err
+		})
+	}
+}
+
+// RateLimitSettings configures the token bucket RateLimiter.
+type RateLimitSettings struct {
+	// Rate is the number of the calls per second.
+	Rate float64 `json:"rate"`
+	// Burst is the number of the calls allowed at once, 1 if not set.
+	Burst int `json:"burst,omitempty"`
+}
+
+func (rs RateLimitSettings) validate() (problems []string) {
+	if rs.Rate <= 0 {
+		problems = append(problems, "rate_limit rate should be positive")
+	}
+	if rs.Burst < 0 {
+		problems = append(problems, "rate_limit burst should not be negative")
+	}
+	return
+}
+
+// RateLimiter is the token bucket limiting the rate of the calls to the client,
+// the call waits for the token or until the context is done.
+type RateLimiter struct {
+	rate  float64
+	burst float64
+	clock Clock
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter the constructor of the RateLimiter, the bucket is full at the start.
+func NewRateLimiter(settings RateLimitSettings, clock Clock) (*RateLimiter, error) {
+	if problems := settings.validate(); len(problems) != 0 {
+		return nil, ConfigError(problems)
+	}
+	burst := float64(settings.Burst)
+	if burst == 0 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   settings.Rate,
+		burst:  burst,
+		clock:  clock,
+		tokens: burst,
+		last:   clock.Now(),
+	}, nil
+}
+
+// reserve takes the token and returns how long to wait until it is available.
+func (rl *RateLimiter) reserve() time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := rl.clock.Now()
+	rl.tokens = math.Min(rl.burst, rl.tokens+now.Sub(rl.last).Seconds()*rl.rate)
+	rl.last = now
+	rl.tokens--
+	if rl.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-rl.tokens / rl.rate * float64(time.Second))
+}
+
+// cancel returns the token reserved by the call which did not happen.
+func (rl *RateLimiter) cancel() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.tokens = math.Min(rl.burst, rl.tokens+1)
+}
+
+// Wait waits for the token to make the call.
+func (rl *RateLimiter) Wait(ctx context.Context) error {
+	delay := rl.reserve()
+	if delay <= 0 {
+		return nil
+	}
+	timer := rl.clock.NewTimer(delay)
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+		timer.Stop()
+		rl.cancel()
+		return fmt.Errorf("waiting for the rate limit: %w", ctx.Err())
+	}
+}
+
+// Middleware returns the middleware calling the client within the rate limit.
+func (rl *RateLimiter) Middleware() ClientMiddleware {
+	return func(next Client) Client {
+		client := AsContextClient(next)
+		return clientFunc(func(ctx context.Context, userIP string, count int) ([]*ContentItem, error) {
+			if err := rl.Wait(ctx); err != nil {
+				return nil, err
+			}
+			return client.GetContentContext(ctx, userIP, count)
+		})
+	}
+}
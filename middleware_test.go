@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingContentProvider counts the calls and fails while the fail flag is set.
+type countingContentProvider struct {
+	switchableContentProvider
+	calls int32
+}
+
+func (cp *countingContentProvider) GetContent(userIP string, count int) ([]*ContentItem, error) {
+	atomic.AddInt32(&cp.calls, 1)
+	return cp.switchableContentProvider.GetContent(userIP, count)
+}
+
+func (cp *countingContentProvider) callCount() int {
+	return int(atomic.LoadInt32(&cp.calls))
+}
+
+func TestChain(t *testing.T) {
+	var order []string
+	record := func(name string) ClientMiddleware {
+		return func(next Client) Client {
+			client := AsContextClient(next)
+			return clientFunc(func(ctx context.Context, userIP string, count int) ([]*ContentItem, error) {
+				order = append(order, name)
+				return client.GetContentContext(ctx, userIP, count)
+			})
+		}
+	}
+	content, err := Chain(SampleContentProvider{Provider1}, record("outer"), record("inner")).GetContent("", 2)
+	assert.NoError(t, err)
+	assert.Len(t, content, 2)
+	assert.Equal(t, []string{"outer", "inner"}, order)
+}
+
+func TestRetryMiddleware(t *testing.T) {
+	policy := RetryPolicy{InitialDelay: time.Millisecond, Multiplier: 2, MaxAttempts: 2}
+	t.Run("succeeds within the attempts", func(t *testing.T) {
+		client := &flakyContentProvider{failures: 2}
+		content, err := RetryMiddleware(policy, realClock{})(client).GetContent("", 2)
+		assert.NoError(t, err)
+		assert.Len(t, content, 2)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&client.calls))
+	})
+	t.Run("fails after the attempts", func(t *testing.T) {
+		client := &flakyContentProvider{failures: 3}
+		_, err := RetryMiddleware(policy, realClock{})(client).GetContent("", 2)
+		assert.EqualError(t, err, "network error")
+		assert.Equal(t, int32(3), atomic.LoadInt32(&client.calls))
+	})
+	t.Run("does not retry the open breaker", func(t *testing.T) {
+		client := &countingContentProvider{}
+		failing := func(next Client) Client {
+			return clientFunc(func(ctx context.Context, userIP string, count int) ([]*ContentItem, error) {
+				_, _ = next.GetContent(userIP, count)
+				return nil, ErrBreakerOpen
+			})
+		}
+		_, err := Chain(client, RetryMiddleware(policy, realClock{}), failing).GetContent("", 2)
+		assert.Equal(t, ErrBreakerOpen, err)
+		assert.Equal(t, 1, client.callCount())
+	})
+	t.Run("stops waiting when the context is done", func(t *testing.T) {
+		clock := newFakeClock()
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			_, err := RetryMiddleware(policy, clock)(failedContentProvider{}).(ContextClient).GetContentContext(ctx, "", 2)
+			done <- err
+		}()
+		clock.BlockUntil(1)
+		cancel()
+		assert.EqualError(t, <-done, "network error")
+	})
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	client := hangingContentProvider{release: make(chan struct{})}
+	defer close(client.release)
+	_, err := TimeoutMiddleware(time.Millisecond*50)(client).GetContent("", 2)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	newBreaker := func(t *testing.T, clock Clock) *CircuitBreaker {
+		breaker, err := NewCircuitBreaker(BreakerSettings{
+			FailureThreshold: 2,
+			OpenTimeout:      Duration(time.Minute),
+			SuccessThreshold: 2,
+		}, clock)
+		assert.NoError(t, err)
+		return breaker
+	}
+	t.Run("opens after the failures and closes after the probes", func(t *testing.T) {
+		clock := newFakeClock()
+		breaker := newBreaker(t, clock)
+		client := &countingContentProvider{}
+		client.setFail(true)
+		wrapped := breaker.Middleware()(client)
+
+		_, err := wrapped.GetContent("", 1)
+		assert.EqualError(t, err, "network error")
+		assert.Equal(t, BreakerClosed, breaker.State())
+		_, err = wrapped.GetContent("", 1)
+		assert.EqualError(t, err, "network error")
+		assert.Equal(t, BreakerOpen, breaker.State())
+
+		_, err = wrapped.GetContent("", 1)
+		assert.Equal(t, ErrBreakerOpen, err)
+		assert.Equal(t, 2, client.callCount())
+
+		clock.Advance(time.Minute)
+		assert.Equal(t, BreakerHalfOpen, breaker.State())
+		client.setFail(false)
+		_, err = wrapped.GetContent("", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, BreakerHalfOpen, breaker.State())
+		_, err = wrapped.GetContent("", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, BreakerClosed, breaker.State())
+	})
+	t.Run("failed probe opens again", func(t *testing.T) {
+		clock := newFakeClock()
+		breaker := newBreaker(t, clock)
+		wrapped := breaker.Middleware()(failedContentProvider{})
+		_, _ = wrapped.GetContent("", 1)
+		_, _ = wrapped.GetContent("", 1)
+		clock.Advance(time.Minute)
+		_, err := wrapped.GetContent("", 1)
+		assert.EqualError(t, err, "network error")
+		assert.Equal(t, BreakerOpen, breaker.State())
+	})
+	t.Run("success resets the failures", func(t *testing.T) {
+		breaker := newBreaker(t, newFakeClock())
+		client := &countingContentProvider{}
+		wrapped := breaker.Middleware()(client)
+		client.setFail(true)
+		_, _ = wrapped.GetContent("", 1)
+		client.setFail(false)
+		_, _ = wrapped.GetContent("", 1)
+		client.setFail(true)
+		_, _ = wrapped.GetContent("", 1)
+		assert.Equal(t, BreakerClosed, breaker.State())
+	})
+	t.Run("invalid settings", func(t *testing.T) {
+		_, err := NewCircuitBreaker(BreakerSettings{}, realClock{})
+		assert.Equal(t, ConfigError{
+			"breaker failure_threshold should be positive",
+			"breaker open_timeout should be positive",
+		}, err)
+	})
+}
+
+func TestRateLimiter(t *testing.T) {
+	t.Run("waits for the token", func(t *testing.T) {
+		clock := newFakeClock()
+		limiter, err := NewRateLimiter(RateLimitSettings{Rate: 1, Burst: 2}, clock)
+		assert.NoError(t, err)
+		assert.NoError(t, limiter.Wait(context.Background()))
+		assert.NoError(t, limiter.Wait(context.Background()))
+		done := make(chan error)
+		go func() {
+			done <- limiter.Wait(context.Background())
+		}()
+		clock.BlockUntil(1)
+		clock.Advance(time.Second)
+		assert.NoError(t, <-done)
+	})
+	t.Run("refills over time up to the burst", func(t *testing.T) {
+		clock := newFakeClock()
+		limiter, err := NewRateLimiter(RateLimitSettings{Rate: 10}, clock)
+		assert.NoError(t, err)
+		assert.Equal(t, time.Duration(0), limiter.reserve())
+		clock.Advance(time.Hour)
+		assert.Equal(t, time.Duration(0), limiter.reserve())
+		assert.Equal(t, time.Millisecond*100, limiter.reserve())
+	})
+	t.Run("gives up when the context is done", func(t *testing.T) {
+		clock := newFakeClock()
+		limiter, err := NewRateLimiter(RateLimitSettings{Rate: 1}, clock)
+		assert.NoError(t, err)
+		client := &countingContentProvider{}
+		wrapped := limiter.Middleware()(client).(ContextClient)
+		_, err = wrapped.GetContentContext(context.Background(), "", 1)
+		assert.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = wrapped.GetContentContext(ctx, "", 1)
+		assert.True(t, errors.Is(err, context.Canceled))
+		assert.Equal(t, 1, client.callCount())
+	})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// persistFormat is the version of the format of the snapshot file, the files of the other versions are ignored.
+const persistFormat = 1
+
+// persistedState is the content of the snapshot file.
+type persistedState struct {
+	Format    int                            `json:"format"`
+	SavedAt   time.Time                      `json:"saved_at"`
+	Providers map[Provider]persistedProvider `json:"providers"`
+}
+
+// persistedProvider is the cached state of one provider in the snapshot file.
+type persistedProvider struct {
+	Content    []*ContentItem `json:"content"`
+	Fails      bool           `json:"fails"`
+	Status     ProviderStatus `json:"status"`
+	StaleUntil time.Time      `json:"stale_until,omitempty"`
+	LastUpdate time.Time      `json:"last_update"`
+}
+
+// WithPersistence makes the TimeExpirationCacher write its state to the file at the path every interval and on stop,
+// and load it on start, so the requests are served from the saved content while the providers are being refreshed.
+func WithPersistence(path string, interval time.Duration) CacherOption {
+	return func(tec *TimeExpirationCacher) {
+		tec.persistPath = path
+		tec.persistInterval = interval
+	}
+}
+
+// persisted returns the state to save. The state lock should be held.
+func (tec *TimeExpirationCacher) persisted() persistedState {
+	ps := persistedState{
+		Format:    persistFormat,
+		SavedAt:   tec.clock.Now(),
+		Providers: make(map[Provider]persistedProvider, len(tec.fetched)),
+	}
+	for p, content := range tec.fetched {
+		ps.Providers[p] = persistedProvider{
+			Content:    content,
+			Fails:      tec.state.fails[p],
+			Status:     tec.state.statuses[p],
+			StaleUntil: tec.state.staleUntil[p],
+			LastUpdate: tec.lastUpdate[p],
+		}
+	}
+	return ps
+}
+
+// save writes the state to the snapshot file. The file is written to a temporary file in the same directory first
+// and renamed then, so the file is never left half written.
+func (tec *TimeExpirationCacher) save() error {
+	tec.stateLock.RLock()
+	ps := tec.persisted()
+	tec.stateLock.RUnlock()
+	bb, err := json.Marshal(ps)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(tec.persistPath), filepath.Base(tec.persistPath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bb); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), tec.persistPath)
+}
+
+// restore loads the state from the snapshot file, and returns the providers restored with the content not expired yet.
+// Only the providers still configured are restored, the missing file restores nothing.
+func (tec *TimeExpirationCacher) restore() (map[Provider]bool, error) {
+	bb, err := os.ReadFile(tec.persistPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ps persistedState
+	if err := json.Unmarshal(bb, &ps); err != nil {
+		return nil, fmt.Errorf("cannot parse the snapshot file %s: %w", tec.persistPath, err)
+	}
+	if ps.Format != persistFormat {
+		return nil, fmt.Errorf("the snapshot file %s has unknown format %d", tec.persistPath, ps.Format)
+	}
+	tec.stateLock.Lock()
+	defer tec.stateLock.Unlock()
+	newState := tec.state.copy()
+	for p, pp := range ps.Providers {
+		if _, ok := tec.providerConfigs[p]; !ok {
+			continue
+		}
+		tec.fetched[p] = pp.Content
+		tec.lastUpdate[p] = pp.LastUpdate
+		newState.fails[p] = pp.Fails
+		newState.statuses[p] = pp.Status
+		if pp.StaleUntil.IsZero() {
+			delete(newState.staleUntil, p)
+		} else {
+			newState.staleUntil[p] = pp.StaleUntil
+		}
+	}
+	tec.buildContent(newState)
+	tec.setState(newState)
+	restored := make(map[Provider]bool, len(ps.Providers))
+	for p := range ps.Providers {
+		restored[p] = len(freshItems(tec.fetched[p], tec.clock.Now())) > 0
+	}
+	return restored, nil
+}
+
+// startPersisting starts the routine saving the state every interval, it saves the state one last time when stopped.
+func (tec *TimeExpirationCacher) startPersisting() *providerRunner {
+	ctx, cancel := context.WithCancel(context.Background())
+	runner := &providerRunner{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(runner.done)
+		for stopped := false; !stopped; {
+			timer := tec.clock.NewTimer(tec.persistInterval)
+			select {
+			case <-timer.C():
+			case <-ctx.Done():
+				timer.Stop()
+				stopped = true
+			}
+			if err := tec.save(); err != nil {
+				log.Printf("cannot save the snapshot file: %v", err)
+			}
+		}
+	}()
+	return runner
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeExpirationCacher_Persistence(t *testing.T) {
+	items := staticContentProvider{{ID: "1"}, {ID: "2", Expiry: newFakeClock().Now().Add(time.Hour)}}
+	ids := func(state State, p Provider) (ids []string) {
+		for i := 0; i < state.Available(p); i++ {
+			ids = append(ids, state.ContentItem(ContentAddress{Provider: p, Index: i}).ID)
+		}
+		return
+	}
+
+	t.Run("the restarted cacher serves the saved content", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "cache.json")
+		clock := newFakeClock()
+		cacher := newTestCacher(t, map[Provider]ProviderConfig{
+			Provider1: {expiration: time.Hour, length: 10, client: items},
+		}, WithClock(clock), WithPersistence(path, time.Minute))
+		cacher.Start()
+		<-cacher.Ready()
+		clock.BlockUntil(2)
+		clock.Advance(time.Minute)
+		clock.BlockUntil(2)
+		files, err := os.ReadDir(dir)
+		assert.NoError(t, err)
+		assert.Len(t, files, 1)
+		cacher.Stop()
+
+		client := hangingContentProvider{release: make(chan struct{})}
+		defer close(client.release)
+		restarted := newTestCacher(t, map[Provider]ProviderConfig{
+			Provider1: {expiration: time.Hour, length: 10, client: client},
+		}, WithClock(clock), WithPersistence(path, time.Minute))
+		restarted.Start()
+		<-restarted.Ready()
+		defer restarted.Stop()
+		state := restarted.GetState()
+		assert.Equal(t, []string{"1", "2"}, ids(state, Provider1))
+		assert.False(t, state.Fails(Provider1))
+		assert.Equal(t, clock.Now().Add(-time.Minute), restarted.lastUpdated(Provider1))
+	})
+	t.Run("the broken file is ignored", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "cache.json")
+		assert.NoError(t, os.WriteFile(path, []byte("{"), 0600))
+		clock := newFakeClock()
+		cacher := newTestCacher(t, map[Provider]ProviderConfig{
+			Provider1: {expiration: time.Hour, length: 10, client: items},
+		}, WithClock(clock), WithPersistence(path, time.Minute))
+		cacher.Start()
+		<-cacher.Ready()
+		defer cacher.Stop()
+		assert.Equal(t, []string{"1", "2"}, ids(cacher.GetState(), Provider1))
+	})
+	t.Run("the expired content is not restored", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "cache.json")
+		clock := newFakeClock()
+		cacher := newTestCacher(t, map[Provider]ProviderConfig{
+			Provider1: {expiration: time.Hour * 2, length: 10, client: staticContentProvider{items[1]}},
+		}, WithClock(clock), WithPersistence(path, time.Hour*2))
+		cacher.Start()
+		<-cacher.Ready()
+		cacher.Stop()
+
+		clock.Advance(time.Hour)
+		restarted := newTestCacher(t, map[Provider]ProviderConfig{
+			Provider1: {expiration: time.Hour, length: 10, client: failedContentProvider{}},
+		}, WithClock(clock), WithPersistence(path, time.Hour))
+		restarted.Start()
+		waitRefreshed(t, restarted)
+		defer restarted.Stop()
+		state := restarted.GetState()
+		assert.True(t, state.Fails(Provider1))
+		assert.Equal(t, 0, state.Available(Provider1))
+	})
+}
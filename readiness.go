@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const readyPath = "/readyz"
+
+// ProviderReadiness is the loading state of a provider.
+type ProviderReadiness string
+
+const (
+	// ReadinessLoading the first refresh of the provider has not finished yet.
+	ReadinessLoading ProviderReadiness = "loading"
+	// ReadinessRestored the provider serves the content restored from the snapshot file,
+	// the first refresh has not finished yet.
+	ReadinessRestored ProviderReadiness = "restored"
+	// ReadinessLoaded the last refresh of the provider succeeded.
+	ReadinessLoaded ProviderReadiness = "loaded"
+	// ReadinessFailed the last refresh of the provider failed.
+	ReadinessFailed ProviderReadiness = "failed"
+)
+
+// Readiness tells if the cache is warm enough to serve, and the loading states of the providers.
+type Readiness struct {
+	Ready     bool                           `json:"ready"`
+	Providers map[Provider]ProviderReadiness `json:"providers"`
+}
+
+// ReadinessReporter reports the readiness of the application.
+type ReadinessReporter interface {
+	// Ready returns the channel closed when the application becomes ready.
+	Ready() <-chan struct{}
+	Readiness() Readiness
+}
+
+// readinessTracker tracks the loading states of the providers. It becomes ready when the quorum of the providers
+// serve the content, or without the quorum when none of them is loading anymore and at least one serves the content.
+// It is forced to be ready by the deadline whatever the states are. Once ready, it stays ready.
+type readinessTracker struct {
+	mu        sync.Mutex
+	quorum    int
+	providers map[Provider]ProviderReadiness
+	ready     chan struct{}
+	isReady   bool
+}
+
+func newReadinessTracker(quorum int) *readinessTracker {
+	return &readinessTracker{
+		quorum:    quorum,
+		providers: make(map[Provider]ProviderReadiness),
+		ready:     make(chan struct{}),
+	}
+}
+
+// track starts tracking the providers not tracked yet as loading.
+func (rt *readinessTracker) track(providers ...Provider) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	for _, p := range providers {
+		if _, ok := rt.providers[p]; !ok {
+			rt.providers[p] = ReadinessLoading
+		}
+	}
+	rt.check()
+}
+
+// restored marks the loading provider as restored from the snapshot file.
+func (rt *readinessTracker) restored(p Provider) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.providers[p] == ReadinessLoading {
+		rt.providers[p] = ReadinessRestored
+	}
+	rt.check()
+}
+
+// refreshed records the outcome of the refresh of the provider.
+func (rt *readinessTracker) refreshed(p Provider, ok bool) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if ok {
+		rt.providers[p] = ReadinessLoaded
+	} else {
+		rt.providers[p] = ReadinessFailed
+	}
+	rt.check()
+}
+
+func (rt *readinessTracker) remove(p Provider) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	delete(rt.providers, p)
+	rt.check()
+}
+
+// force makes the tracker ready whatever the states of the providers are.
+func (rt *readinessTracker) force() {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.setReady()
+}
+
+// check makes the tracker ready if the providers are. The lock should be held.
+func (rt *readinessTracker) check() {
+	serving, loading := 0, 0
+	for _, r := range rt.providers {
+		switch r {
+		case ReadinessLoaded, ReadinessRestored:
+			serving++
+		case ReadinessLoading:
+			loading++
+		}
+	}
+	settled := loading == 0 && (serving > 0 || len(rt.providers) == 0)
+	if rt.quorum > 0 && serving >= rt.quorum || rt.quorum == 0 && settled {
+		rt.setReady()
+	}
+}
+
+func (rt *readinessTracker) setReady() {
+	if !rt.isReady {
+		rt.isReady = true
+		close(rt.ready)
+	}
+}
+
+func (rt *readinessTracker) readiness() Readiness {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	r := Readiness{
+		Ready:     rt.isReady,
+		Providers: make(map[Provider]ProviderReadiness, len(rt.providers)),
+	}
+	for p, pr := range rt.providers {
+		r.Providers[p] = pr
+	}
+	return r
+}
+
+// WithReadiness makes the TimeExpirationCacher ready when quorum providers serve the content,
+// or when the deadline after the start has passed (no deadline if 0).
+// Without the option the TimeExpirationCacher is ready when every provider has finished its first refresh
+// and at least one of them serves the content.
+func WithReadiness(quorum int, deadline time.Duration) CacherOption {
+	return func(tec *TimeExpirationCacher) {
+		tec.readiness = newReadinessTracker(quorum)
+		tec.readinessDeadline = deadline
+	}
+}
+
+// Ready returns the channel closed when the cache becomes ready to serve.
+func (tec *TimeExpirationCacher) Ready() <-chan struct{} {
+	return tec.readiness.ready
+}
+
+// Readiness returns the readiness of the cache and the loading states of the providers.
+func (tec *TimeExpirationCacher) Readiness() Readiness {
+	return tec.readiness.readiness()
+}
+
+// startDeadline starts the routine forcing the readiness when the deadline passes.
+func (tec *TimeExpirationCacher) startDeadline() *providerRunner {
+	ctx, cancel := context.WithCancel(context.Background())
+	runner := &providerRunner{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(runner.done)
+		timer := tec.clock.NewTimer(tec.readinessDeadline)
+		select {
+		case <-timer.C():
+			tec.readiness.force()
+		case <-tec.readiness.ready:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}()
+	return runner
+}
+
+func writeReadinessResponse(w http.ResponseWriter, readiness Readiness) {
+	bb, err := json.Marshal(readiness)
+	if err != nil {
+		writeErrorResponse(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if readiness.Ready {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if _, err := w.Write(bb); err != nil {
+		log.Println("error when trying to write data to HTTP response: " + err.Error())
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func isReady(rt *readinessTracker) bool {
+	select {
+	case <-rt.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestReadinessTracker(t *testing.T) {
+	t.Run("ready when no provider is loading and one serves", func(t *testing.T) {
+		rt := newReadinessTracker(0)
+		rt.track(Provider1, Provider2)
+		rt.refreshed(Provider1, true)
+		assert.False(t, isReady(rt))
+		rt.refreshed(Provider2, false)
+		assert.True(t, isReady(rt))
+		assert.Equal(t, Readiness{
+			Ready:     true,
+			Providers: map[Provider]ProviderReadiness{Provider1: ReadinessLoaded, Provider2: ReadinessFailed},
+		}, rt.readiness())
+	})
+	t.Run("not ready when every provider has failed", func(t *testing.T) {
+		rt := newReadinessTracker(0)
+		rt.track(Provider1)
+		rt.refreshed(Provider1, false)
+		assert.False(t, isReady(rt))
+		rt.refreshed(Provider1, true)
+		assert.True(t, isReady(rt))
+	})
+	t.Run("ready when the quorum serves", func(t *testing.T) {
+		rt := newReadinessTracker(2)
+		rt.track(Provider1, Provider2, Provider3)
+		rt.refreshed(Provider1, true)
+		rt.refreshed(Provider2, false)
+		assert.False(t, isReady(rt))
+		rt.restored(Provider3)
+		assert.True(t, isReady(rt))
+	})
+	t.Run("not ready when the failed providers leave the quorum short", func(t *testing.T) {
+		rt := newReadinessTracker(2)
+		rt.track(Provider1, Provider2, Provider3)
+		rt.refreshed(Provider1, true)
+		rt.refreshed(Provider2, false)
+		rt.refreshed(Provider3, false)
+		assert.False(t, isReady(rt))
+		rt.force()
+		assert.True(t, isReady(rt))
+	})
+	t.Run("stays ready", func(t *testing.T) {
+		rt := newReadinessTracker(0)
+		rt.track(Provider1)
+		rt.refreshed(Provider1, true)
+		rt.track(Provider2)
+		assert.True(t, rt.readiness().Ready)
+		assert.Equal(t, ReadinessLoading, rt.readiness().Providers[Provider2])
+	})
+	t.Run("no providers", func(t *testing.T) {
+		rt := newReadinessTracker(0)
+		rt.track()
+		assert.True(t, isReady(rt))
+	})
+}
+
+func TestTimeExpirationCacher_Readiness(t *testing.T) {
+	t.Run("start does not wait for the hanging provider", func(t *testing.T) {
+		client := hangingContentProvider{release: make(chan struct{})}
+		defer close(client.release)
+		clock := newFakeClock()
+		cacher := newTestCacher(t, map[Provider]ProviderConfig{
+			Provider1: {expiration: time.Minute, length: 10, client: SampleContentProvider{Provider1}},
+			Provider2: {expiration: time.Minute, length: 10, client: client},
+		}, WithClock(clock), WithReadiness(0, time.Minute))
+		cacher.Start()
+		defer cacher.Stop()
+		clock.BlockUntil(2)
+		assert.Equal(t, Readiness{
+			Ready:     false,
+			Providers: map[Provider]ProviderReadiness{Provider1: ReadinessLoaded, Provider2: ReadinessLoading},
+		}, cacher.Readiness())
+		clock.Advance(time.Minute)
+		<-cacher.Ready()
+		assert.True(t, cacher.Readiness().Ready)
+	})
+	t.Run("the quorum has loaded", func(t *testing.T) {
+		client := hangingContentProvider{release: make(chan struct{})}
+		defer close(client.release)
+		cacher := newTestCacher(t, map[Provider]ProviderConfig{
+			Provider1: {expiration: time.Minute, length: 10, client: SampleContentProvider{Provider1}},
+			Provider2: {expiration: time.Minute, length: 10, client: client},
+		}, WithReadiness(1, 0))
+		cacher.Start()
+		defer cacher.Stop()
+		<-cacher.Ready()
+		assert.Equal(t, 10, cacher.GetState().Available(Provider1))
+	})
+}
+
+// releasedContentProvider blocks until released, and then serves the content.
+type releasedContentProvider struct {
+	release chan struct{}
+	content staticContentProvider
+}
+
+func (cp releasedContentProvider) GetContent(userIP string, count int) ([]*ContentItem, error) {
+	<-cp.release
+	return cp.content.GetContent(userIP, count)
+}
+
+func TestReadinessEndpoint(t *testing.T) {
+	client := releasedContentProvider{release: make(chan struct{}), content: staticContentProvider{{ID: "1"}}}
+	cacher := newTestCacher(t, map[Provider]ProviderConfig{
+		Provider1: {expiration: time.Minute, length: 10, client: client},
+	})
+	cacher.Start()
+	defer cacher.Stop()
+	app := App{Service: MakeService(cacher, MakeConfiguredSequencer(ContentMix{config1})), Readiness: cacher}
+
+	response := httptest.NewRecorder()
+	app.ServeHTTP(response, httptest.NewRequest(http.MethodGet, readyPath, nil))
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+	var readiness Readiness
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &readiness))
+	assert.Equal(t, Readiness{Providers: map[Provider]ProviderReadiness{Provider1: ReadinessLoading}}, readiness)
+
+	close(client.release)
+	<-cacher.Ready()
+	response = httptest.NewRecorder()
+	app.ServeHTTP(response, httptest.NewRequest(http.MethodGet, readyPath, nil))
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "application/json", response.Header().Get("Content-Type"))
+}
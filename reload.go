@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+// Reloader applies a new configuration to the running application without restarting it.
+// The providers which settings did not change keep their cached content and refresh routines,
+// the content mix is swapped atomically, so the requests in flight finish against the old one.
+type Reloader struct {
+	mu        sync.Mutex
+	config    AppConfig
+	load      func() (AppConfig, error)
+	cacher    *TimeExpirationCacher
+	sequencer *SwappableSequencer
+}
+
+// NewReloader the constructor of the Reloader, config is the configuration the application is running with,
+// load returns the new configuration on every reload.
+func NewReloader(config AppConfig, load func() (AppConfig, error), cacher *TimeExpirationCacher,
+	sequencer *SwappableSequencer) *Reloader {
+	return &Reloader{
+		config:    config,
+		load:      load,
+		cacher:    cacher,
+		sequencer: sequencer,
+	}
+}
+
+// Reload loads the configuration and applies it.
+// The new and changed providers are loaded before the content mix is swapped,
+// the removed providers are dropped after it.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	config, err := r.load()
+	if err != nil {
+		return err
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if config.Listen != r.config.Listen {
+		log.Printf("the listen address cannot be changed without restart, still listening on %s", r.config.Listen)
+		config.Listen = r.config.Listen
+	}
+	if config.AdminListen != r.config.AdminListen {
+		log.Printf("the admin listen address cannot be changed without restart, keeping %q", r.config.AdminListen)
+		config.AdminListen = r.config.AdminListen
+	}
+	if config.DisableRootAlias != r.config.DisableRootAlias {
+		log.Print("the root alias cannot be changed without restart, keeping the current setting")
+		config.DisableRootAlias = r.config.DisableRootAlias
+	}
+	if !reflect.DeepEqual(config.Snapshots, r.config.Snapshots) {
+		log.Print("the snapshots settings cannot be changed without restart, keeping the current ones")
+		config.Snapshots = r.config.Snapshots
+	}
+	if !reflect.DeepEqual(config.Dedup, r.config.Dedup) {
+		log.Print("the dedup settings cannot be changed without restart, keeping the current ones")
+		config.Dedup = r.config.Dedup
+	}
+	if !reflect.DeepEqual(config.Persistence, r.config.Persistence) {
+		log.Print("the persistence settings cannot be changed without restart, keeping the current ones")
+		config.Persistence = r.config.Persistence
+	}
+	// the readiness applies to the start only
+	config.Readiness = r.config.Readiness
+
+	changed := make(map[Provider]ProviderConfig)
+	for p, ps := range config.Providers {
+		if old, ok := r.config.Providers[p]; ok && reflect.DeepEqual(old, ps) {
+			continue
+		}
+		pc, err := ps.providerConfig(p)
+		if err != nil {
+			return err
+		}
+		changed[p] = pc
+	}
+	// the changed providers are loaded together, so the reload waits for the slowest of them only
+	if err := r.cacher.SetProviders(changed); err != nil {
+		return fmt.Errorf("cannot apply the providers: %w", err)
+	}
+	for p := range changed {
+		log.Printf("provider %q is (re)started", p)
+	}
+
+	// the swap expires the cursors, so the sequencer is swapped only if its settings changed
+	if !config.sameLayout(r.config) {
+		r.sequencer.Swap(config.sequencer())
+	}
+
+	for p := range r.config.Providers {
+		if _, ok := config.Providers[p]; !ok {
+			r.cacher.RemoveProvider(p)
+			log.Printf("provider %q is removed", p)
+		}
+	}
+	r.config = config
+	return nil
+}
+
+// ServeHTTP reloads the configuration on the POST request.
+func (r *Reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeMethodNotAllowedResponse(w, req)
+		return
+	}
+	if err := r.Reload(); err != nil {
+		writeErrorResponse(w, clientError{
+			code:    CodeReloadFailed,
+			status:  http.StatusUnprocessableEntity,
+			message: "cannot reload the configuration: " + err.Error(),
+		})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte("configuration reloaded")); err != nil {
+		log.Println("error when trying to write data to HTTP response: " + err.Error())
+	}
+}
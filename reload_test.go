@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func reloadTestConfig(providers map[Provider]int, mix ContentMix) AppConfig {
+	config := AppConfig{
+		Listen:    "127.0.0.1:8080",
+		Providers: make(map[Provider]ProviderSettings, len(providers)),
+		Mix:       mix,
+	}
+	for p, length := range providers {
+		config.Providers[p] = ProviderSettings{
+			Client:          sampleClient,
+			RefreshInterval: Duration(time.Minute * 10),
+			Length:          length,
+		}
+	}
+	return config
+}
+
+func startReloadTest(t *testing.T, config AppConfig) (*Reloader, *TimeExpirationCacher, *SwappableSequencer, *AppConfig) {
+	providerConfigs, err := config.ProviderConfigs()
+	assert.NoError(t, err)
+	cacher := newTestCacher(t, providerConfigs)
+	cacher.Start()
+	<-cacher.Ready()
+	t.Cleanup(cacher.Stop)
+	sequencer := NewSwappableSequencer(config.sequencer())
+	next := config
+	reloader := NewReloader(config, func() (AppConfig, error) { return next, nil }, cacher, sequencer)
+	return reloader, cacher, sequencer, &next
+}
+
+func TestReloader_Reload(t *testing.T) {
+	t.Run("providers are added, removed and kept", func(t *testing.T) {
+		config := reloadTestConfig(map[Provider]int{Provider1: 10, Provider2: 10}, ContentMix{{Type: Provider1}, {Type: Provider2}})
+		reloader, cacher, sequencer, next := startReloadTest(t, config)
+		kept := cacher.GetState().ContentItem(ContentAddress{Provider: Provider1, Index: 0})
+
+		*next = reloadTestConfig(map[Provider]int{Provider1: 10, Provider3: 5}, ContentMix{{Type: Provider3}, {Type: Provider1}})
+		assert.NoError(t, reloader.Reload())
+
+		state := cacher.GetState()
+		assert.Same(t, kept, state.ContentItem(ContentAddress{Provider: Provider1, Index: 0}))
+		assert.NotNil(t, state.ContentItem(ContentAddress{Provider: Provider3, Index: 4}))
+		assert.Nil(t, state.ContentItem(ContentAddress{Provider: Provider2, Index: 0}))
+		addresses, err := sequencer.Sequence(state, 2, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, []ContentAddress{{Provider: Provider3, Index: 0}, {Provider: Provider1, Index: 0}}, addresses)
+	})
+	t.Run("changed provider is reloaded", func(t *testing.T) {
+		config := reloadTestConfig(map[Provider]int{Provider1: 10}, ContentMix{{Type: Provider1}})
+		reloader, cacher, sequencer, next := startReloadTest(t, config)
+		layout := sequencer.LayoutVersion()
+
+		*next = reloadTestConfig(map[Provider]int{Provider1: 20}, ContentMix{{Type: Provider1}})
+		assert.NoError(t, reloader.Reload())
+
+		assert.NotNil(t, cacher.GetState().ContentItem(ContentAddress{Provider: Provider1, Index: 19}))
+		assert.Equal(t, layout, sequencer.LayoutVersion(), "the same mix is not swapped")
+	})
+	t.Run("invalid config is not applied", func(t *testing.T) {
+		config := reloadTestConfig(map[Provider]int{Provider1: 10}, ContentMix{{Type: Provider1}})
+		reloader, cacher, sequencer, next := startReloadTest(t, config)
+		state := cacher.GetState()
+
+		*next = reloadTestConfig(map[Provider]int{Provider2: 10}, ContentMix{{Type: Provider1}})
+		assert.Error(t, reloader.Reload())
+
+		assert.Equal(t, state, cacher.GetState())
+		addresses, err := sequencer.Sequence(state, 1, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, []ContentAddress{{Provider: Provider1, Index: 0}}, addresses)
+	})
+	t.Run("load error", func(t *testing.T) {
+		config := reloadTestConfig(map[Provider]int{Provider1: 10}, ContentMix{{Type: Provider1}})
+		reloader, _, _, _ := startReloadTest(t, config)
+		reloader.load = func() (AppConfig, error) { return AppConfig{}, errors.New("no file") }
+		assert.Error(t, reloader.Reload())
+	})
+}
+
+func TestReloader_ServeHTTP(t *testing.T) {
+	config := reloadTestConfig(map[Provider]int{Provider1: 10}, ContentMix{{Type: Provider1}})
+	reloader, _, _, _ := startReloadTest(t, config)
+	app := App{Reloader: reloader}
+	admin := app.AdminHandler()
+
+	response := httptest.NewRecorder()
+	app.ServeHTTP(response, httptest.NewRequest(http.MethodPost, reloadPath, nil))
+	assert.Equal(t, http.StatusNotFound, response.Code, "the reload is not served with the content")
+
+	response = httptest.NewRecorder()
+	admin.ServeHTTP(response, httptest.NewRequest(http.MethodGet, reloadPath, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, response.Code)
+	assert.Equal(t, http.MethodPost, response.Header().Get("Allow"))
+
+	response = httptest.NewRecorder()
+	admin.ServeHTTP(response, httptest.NewRequest(http.MethodPost, reloadPath, nil))
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	reloader.load = func() (AppConfig, error) { return AppConfig{}, errors.New("no file") }
+	response = httptest.NewRecorder()
+	admin.ServeHTTP(response, httptest.NewRequest(http.MethodPost, reloadPath, nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, response.Code)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	contentPath = "/v1/content"
+	// contentV2Path serves the content in the envelope with the pagination metadata.
+	contentV2Path = "/v2/content"
+	// rootPath serves the content as well, for the clients made before the versioned paths.
+	rootPath = "/"
+)
+
+// route is the endpoint of the App: the path, the methods it accepts and the handler.
+// The route accepting GET accepts HEAD as well.
+type route struct {
+	path    string
+	methods []string
+	handler http.HandlerFunc
+}
+
+// router dispatches the requests to the routes by the exact path. It responds with 404 to the unknown path,
+// and with 405 and the Allow header to the method the route does not accept.
+type router []route
+
+func (rt router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	for _, r := range rt {
+		if r.path != req.URL.Path {
+			continue
+		}
+		methods := r.allowed()
+		for _, method := range methods {
+			if method == req.Method {
+				r.handler(w, req)
+				return
+			}
+		}
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		writeMethodNotAllowedResponse(w, req)
+		return
+	}
+	writeNotFoundResponse(w, req)
+}
+
+func (r route) allowed() []string {
+	methods := append([]string(nil), r.methods...)
+	for _, method := range r.methods {
+		if method == http.MethodGet {
+			methods = append(methods, http.MethodHead)
+		}
+	}
+	return methods
+}
+
+func writeNotFoundResponse(w http.ResponseWriter, req *http.Request) {
+	writeErrorResponse(w, clientError{code: CodeNotFound, status: http.StatusNotFound, message: "not found: " + req.URL.Path})
+}
+
+func writeMethodNotAllowedResponse(w http.ResponseWriter, req *http.Request) {
+	writeErrorResponse(w, clientError{
+		code:    CodeMethodNotAllowed,
+		status:  http.StatusMethodNotAllowed,
+		message: "method not allowed: " + req.Method,
+	})
+}
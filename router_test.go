@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter(t *testing.T) {
+	app, stop := mustBootstrapApp(t)
+	defer stop()
+	serve := func(app App, method, target string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		app.ServeHTTP(response, httptest.NewRequest(method, target, nil))
+		return response
+	}
+
+	t.Run("the content is served at the versioned path", func(t *testing.T) {
+		content := runRequest(t, app, httptest.NewRequest(http.MethodGet, "/v1/content?count=5", nil))
+		assert.Len(t, content, 5)
+		assert.Equal(t, http.StatusOK, serve(app, http.MethodHead, contentPath).Code)
+	})
+	t.Run("the root path is the alias", func(t *testing.T) {
+		content := runRequest(t, app, httptest.NewRequest(http.MethodGet, "/?count=5", nil))
+		assert.Len(t, content, 5)
+	})
+	t.Run("the method is not allowed", func(t *testing.T) {
+		response := serve(app, http.MethodPost, contentPath)
+		assert.Equal(t, http.StatusMethodNotAllowed, response.Code)
+		assert.Equal(t, "GET, HEAD", response.Header().Get("Allow"))
+		response = serve(app, http.MethodGet, reloadPath)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+	t.Run("unknown path", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, serve(app, http.MethodPost, "/favicon.ico").Code)
+		assert.Equal(t, http.StatusNotFound, serve(app, http.MethodGet, "/v1/content/").Code)
+	})
+	t.Run("the root alias is disabled", func(t *testing.T) {
+		app := app
+		app.DisableRootAlias = true
+		assert.Equal(t, http.StatusNotFound, serve(app, http.MethodGet, "/").Code)
+		assert.Equal(t, http.StatusOK, serve(app, http.MethodGet, contentPath).Code)
+	})
+}
@@ -1,50 +1,421 @@
 package main
 
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+const maxInt = int(^uint(0) >> 1)
+
+// maxPinnedLayout limits the number of the slots the ConfiguredSequencer lays out to place the pinned slots over the mix.
+const maxPinnedLayout = 100000
+
+// errPinnedLayout is returned by the ConfiguredSequencer which pinned slots take too many slots to lay out over the mix.
+var errPinnedLayout = fmt.Errorf("laying the pinned slots over the mix takes more than %d slots", maxPinnedLayout)
+
 // ConfiguredSequencer is the strategy for ordering the content items at the output page.
 type ConfiguredSequencer struct {
 	config ContentMix
+	pinned []PinnedSlot
+	// head are the slots before the absolute pinned positions end, cycle are the slots repeating after them.
+	head  []slot
+	cycle []slot
+	// err rejects every page if the layout could not be made.
+	err error
 }
 
-// MakeConfiguredSequencer the constructor for the ConfiguredSequencer
-func MakeConfiguredSequencer(config ContentMix) ConfiguredSequencer {
-	return ConfiguredSequencer{
+// MakeConfiguredSequencer the constructor for the ConfiguredSequencer, the pinned slots are laid over the mix.
+// The pinned slots which take more than maxPinnedLayout slots to lay out are not laid out,
+// the sequencer returns the error on every page then.
+func MakeConfiguredSequencer(config ContentMix, pinned ...PinnedSlot) ConfiguredSequencer {
+	sq := ConfiguredSequencer{
 		config: config,
+		pinned: pinned,
+	}
+	if len(config) == 0 {
+		return sq
+	}
+	start, length := pinnedLayout(len(config), pinned)
+	if !fitsPinnedLayout(start, length) {
+		sq.err = errPinnedLayout
+		return sq
+	}
+	chains := make([][]Provider, len(config))
+	for i, cc := range config {
+		chains[i] = cc.chain()
+	}
+	pinnedChains := make([][]Provider, len(pinned))
+	for i, ps := range pinned {
+		pinnedChains[i] = ps.chain()
+	}
+	slots := make([]slot, 0, start+length)
+	for position, mixed := 0, 0; position < start+length; position++ {
+		if i, ok := pinAt(pinned, position); ok {
+			slots = append(slots, slot{chain: pinnedChains[i], onFailure: pinned[i].OnFailure.or(PolicySkip)})
+			continue
+		}
+		cc := config[mixed%len(config)]
+		slots = append(slots, slot{chain: chains[mixed%len(chains)], onFailure: cc.OnFailure.or(PolicyTruncate)})
+		mixed++
+	}
+	sq.head, sq.cycle = slots[:start], slots[start:]
+	return sq
+}
+
+// pinnedLayout returns the number of the slots before the absolute pinned positions end,
+// and the length of the cycle the mix and the periodic pinned slots repeat with after them.
+// The cycle is a multiple of the period of the pinned slots, long enough for the mix to make the whole number of the cycles.
+// The length is maxInt if the layout takes more than maxPinnedLayout slots.
+func pinnedLayout(mixLength int, pinned []PinnedSlot) (start, length int) {
+	period := 1
+	for _, ps := range pinned {
+		for _, position := range ps.Positions {
+			if position >= maxPinnedLayout {
+				return position, maxInt
+			}
+			if position >= start {
+				start = position + 1
+			}
+		}
+		if ps.Every > maxPinnedLayout {
+			return start, maxInt
+		}
+		if ps.Every > 0 {
+			period = lcm(period, ps.Every)
+		}
+		if period > maxPinnedLayout {
+			return start, maxInt
+		}
+	}
+	pinnedInPeriod := 0
+	for position := start; position < start+period; position++ {
+		if _, ok := pinAt(pinned, position); ok {
+			pinnedInPeriod++
+		}
+	}
+	periods := mixLength / gcd(period-pinnedInPeriod, mixLength)
+	if periods > maxPinnedLayout/period {
+		return start, maxInt
+	}
+	return start, period * periods
+}
+
+// fitsPinnedLayout returns if the layout of pinnedLayout takes no more than maxPinnedLayout slots.
+func fitsPinnedLayout(start, length int) bool {
+	return start <= maxPinnedLayout && length <= maxPinnedLayout-start
+}
+
+// pinAt returns the first pinned slot taking the position.
+func pinAt(pinned []PinnedSlot, position int) (int, bool) {
+	for i, ps := range pinned {
+		if ps.Every > 0 && (position+1)%ps.Every == 0 {
+			return i, true
+		}
+		for _, p := range ps.Positions {
+			if p == position {
+				return i, true
+			}
+		}
 	}
+	return 0, false
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+func lcm(a, b int) int {
+	return a / gcd(a, b) * b
 }
 
 // Sequence constructs the page of content addresses (provider+index) having the configuration,
 // the information about the failing providers and the offset+limit.
-func (sq ConfiguredSequencer) Sequence(state FailsState, limit, offset int) (addresses []ContentAddress, err error) {
+// The slot of the failing or exhausted provider is served by the first of its fallbacks which is neither failing nor exhausted,
+// The slot neither the provider nor the fallbacks can serve is handled by its failure policy:
+// the page ends at the slot by default, and the pinned slot is skipped, so the next items move up.
+// The mix is the repeating cycle, so the full cycles before the offset are skipped arithmetically,
+// the cost does not depend on the offset.
+func (sq ConfiguredSequencer) Sequence(state FailsState, limit, offset int) ([]ContentAddress, error) {
+	page, err := sq.SequencePage(state, limit, offset)
+	return page.Addresses, err
+}
+
+// SequencePage constructs the page the same way as Sequence, and reports the slots of the page which could not be served.
+func (sq ConfiguredSequencer) SequencePage(state FailsState, limit, offset int) (Page, error) {
+	if sq.err != nil {
+		return Page{}, sq.err
+	}
+	return sequence(sq, state, limit, offset)
+}
+
+func (sq ConfiguredSequencer) prefix() []slot {
+	return sq.head
+}
+
+func (sq ConfiguredSequencer) length() int {
+	return len(sq.cycle)
+}
+
+func (sq ConfiguredSequencer) slots(int) []slot {
+	return sq.cycle
+}
+
+// slot is the position of the sequence, served by the first provider of the chain which can serve it.
+type slot struct {
+	chain     []Provider
+	onFailure FailurePolicy
+}
+
+// slotCycle is the sequence of the slots repeating after the prefix.
+type slotCycle interface {
+	// prefix returns the slots before the first cycle.
+	prefix() []slot
+	// length is the number of the slots in the cycle.
+	length() int
+	// slots returns the slots of the n-th repetition of the cycle.
+	// Every repetition has the same slots, the order of the slots may differ.
+	slots(n int) []slot
+}
+
+// sequence walks the slots from the start and returns the page.
+// The providers serve the same number of the items in every full cycle until one of them fails to serve a slot,
+// so the cycles before the offset are skipped arithmetically.
+// The failures of the skipped and placeholder slots are reported within the page only, the truncation is always reported.
+// The slots are reported only if the provider of the slot fails, not if it has run out of the items.
+// The substitutions are reported within the page only.
+// In the cycle, the sequence ends instead of the placeholder when no provider of the cycle has an item to serve anymore,
+// so the page does not go on with the placeholders only.
+func sequence(cycle slotCycle, state FailsState, limit, offset int) (page Page, err error) {
 	if limit < 0 || offset < 0 {
 		err = ValidationError("limit and offset should be positive")
 		return
 	}
-	providersIndex := make(map[Provider]int, len(sq.config))
-	addresses = make([]ContentAddress, 0, limit)
-	var configIndex int
-	for i := 0; i < offset+limit; i++ {
-		config := sq.config[configIndex]
-		provider := config.Type
-		if state.Fails(config.Type) {
-			if config.Fallback != nil && !state.Fails(*config.Fallback) {
-				provider = *config.Fallback
-			} else {
-				return
+	if offset > maxInt-limit {
+		err = ParameterError{Parameter: "offset", Reason: "is too large"}
+		return
+	}
+	page.Addresses = make([]ContentAddress, 0)
+	providersIndex := make(map[Provider]int)
+	served := 0
+	inCycle := false
+	var cycleProviders []Provider
+	// serve adds the slot to the page, and returns false if the sequence ends at the slot.
+	serve := func(s slot) bool {
+		provider, ok := resolve(s.chain, state, providersIndex)
+		if !ok {
+			if s.onFailure == PolicyPlaceholder && inCycle {
+				if cycleProviders == nil {
+					cycleProviders = providersOf(cycle.slots(0))
+				}
+				if !anyServes(cycleProviders, state, providersIndex) {
+					return false
+				}
+			}
+			// the slot of the provider which has run out of the items is the end of its content, not the failure
+			failing := state.Fails(s.chain[0])
+			if failing && (served >= offset || s.onFailure == PolicyTruncate) {
+				page.Failures = append(page.Failures, SlotFailure{Provider: s.chain[0], Policy: s.onFailure})
+			}
+			switch s.onFailure {
+			case PolicySkip:
+				return true
+			case PolicyPlaceholder:
+				if served >= offset {
+					page.Addresses = append(page.Addresses, ContentAddress{Provider: s.chain[0], Placeholder: true})
+				}
+				served++
+				return true
+			default:
+				return false
 			}
 		}
-		if i >= offset {
-			addresses = append(addresses, ContentAddress{
+		if served >= offset {
+			page.Addresses = append(page.Addresses, ContentAddress{
 				Provider: provider,
 				Index:    providersIndex[provider],
 			})
+			if provider != s.chain[0] {
+				page.Substitutions = append(page.Substitutions, Substitution{Provider: s.chain[0], Fallback: provider})
+			}
 		}
 		providersIndex[provider]++
+		served++
+		return true
+	}
 
-		if configIndex >= len(sq.config)-1 {
-			configIndex = 0
-		} else {
-			configIndex++
+	for _, s := range cycle.prefix() {
+		if served >= offset+limit || !serve(s) {
+			return
+		}
+	}
+	length := cycle.length()
+	if length == 0 {
+		return
+	}
+	inCycle = true
+	var slots []slot
+	servedBefore := -1
+	for position := 0; served < offset+limit; position++ {
+		if position%length == 0 {
+			if served == servedBefore {
+				// nothing was served in the whole cycle, so nothing is going to be
+				return
+			}
+			servedBefore = served
+			slots = cycle.slots(position / length)
+			if served < offset {
+				if plan, ok := makePlan(slots, state, providersIndex); ok {
+					if plan.served == 0 {
+						return
+					}
+					if cycles := plan.skip(state, providersIndex, (offset-served)/plan.served); cycles > 0 {
+						served += cycles * plan.served
+						position += cycles*length - 1
+						continue
+					}
+				}
+			}
+		}
+		if !serve(slots[position%length]) {
+			return
 		}
 	}
 	return
 }
+
+// providersOf returns the providers of the chains of the slots.
+func providersOf(slots []slot) []Provider {
+	providers := make([]Provider, 0)
+	for _, s := range slots {
+		for _, p := range s.chain {
+			providers = appendProvider(providers, p)
+		}
+	}
+	return providers
+}
+
+// anyServes returns if any of the providers has an item to serve.
+func anyServes(providers []Provider, state FailsState, providersIndex map[Provider]int) bool {
+	for _, p := range providers {
+		if canServe(p, state, providersIndex) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve returns the first provider of the slot chain which neither fails nor has run out of the items,
+// and false if none of them can serve the slot.
+func resolve(chain []Provider, state FailsState, providersIndex map[Provider]int) (Provider, bool) {
+	for _, provider := range chain {
+		if canServe(provider, state, providersIndex) {
+			return provider, true
+		}
+	}
+	return "", false
+}
+
+func canServe(provider Provider, state FailsState, providersIndex map[Provider]int) bool {
+	return !state.Fails(provider) && providersIndex[provider] < state.Available(provider)
+}
+
+// cyclePlan is the number of the items every provider serves in the full cycle of the slots,
+// and the number of the slots served including the placeholders.
+type cyclePlan struct {
+	perProvider map[Provider]int
+	served      int
+}
+
+// makePlan resolves the cycle starting at the given provider indexes, and returns false if the sequence ends in the cycle.
+func makePlan(slots []slot, state FailsState, providersIndex map[Provider]int) (cyclePlan, bool) {
+	plan := cyclePlan{perProvider: make(map[Provider]int, len(slots))}
+	for _, s := range slots {
+		provider, ok := resolve(s.chain, state, providersIndex)
+		switch {
+		case ok:
+			plan.perProvider[provider]++
+			plan.served++
+		case s.onFailure == PolicySkip:
+		case s.onFailure == PolicyPlaceholder:
+			plan.served++
+		default:
+			return cyclePlan{}, false
+		}
+	}
+	if len(plan.perProvider) == 0 {
+		// only the placeholders are left, the sequence ends at the first of them
+		return cyclePlan{}, false
+	}
+	return plan, true
+}
+
+// skip advances the provider indexes by up to the given number of the full cycles, as long as every provider
+// has enough items for the cycles, so the cycles are served the same way. It returns the number of the skipped cycles.
+func (cp cyclePlan) skip(state FailsState, providersIndex map[Provider]int, cycles int) int {
+	for provider, perCycle := range cp.perProvider {
+		if left := (state.Available(provider) - providersIndex[provider]) / perCycle; left < cycles {
+			cycles = left
+		}
+	}
+	for provider, perCycle := range cp.perProvider {
+		providersIndex[provider] += cycles * perCycle
+	}
+	return cycles
+}
+
+// SwappableSequencer is the Sequencer which strategy can be replaced at runtime, e.g. when the configuration is reloaded.
+// Every call to Sequence is served by the strategy set at the moment of the call.
+// Every swap is the next layout version, so the cursors made before it expire.
+type SwappableSequencer struct {
+	current atomic.Value
+	layout  uint64
+}
+
+// sequencerHolder keeps the concrete type stored in the atomic.Value the same for all the strategies.
+type sequencerHolder struct {
+	Sequencer
+}
+
+// NewSwappableSequencer the constructor for the SwappableSequencer
+func NewSwappableSequencer(sequencer Sequencer) *SwappableSequencer {
+	ss := &SwappableSequencer{}
+	ss.Swap(sequencer)
+	return ss
+}
+
+// Swap replaces the strategy.
+func (ss *SwappableSequencer) Swap(sequencer Sequencer) {
+	ss.current.Store(sequencerHolder{sequencer})
+	atomic.AddUint64(&ss.layout, 1)
+}
+
+// LayoutVersion returns the number of the swaps.
+func (ss *SwappableSequencer) LayoutVersion() uint64 {
+	return atomic.LoadUint64(&ss.layout)
+}
+
+// Sequence delegates to the current strategy.
+func (ss *SwappableSequencer) Sequence(state FailsState, limit, offset int) ([]ContentAddress, error) {
+	holder, ok := ss.current.Load().(sequencerHolder)
+	if !ok {
+		return nil, errors.New("no sequencer is set")
+	}
+	return holder.Sequence(state, limit, offset)
+}
+
+// SequencePage delegates to the current strategy, the failures are reported if the strategy is the PageSequencer.
+func (ss *SwappableSequencer) SequencePage(state FailsState, limit, offset int) (Page, error) {
+	holder, ok := ss.current.Load().(sequencerHolder)
+	if !ok {
+		return Page{}, errors.New("no sequencer is set")
+	}
+	if ps, ok := holder.Sequencer.(PageSequencer); ok {
+		return ps.SequencePage(state, limit, offset)
+	}
+	addresses, err := holder.Sequence(state, limit, offset)
+	return Page{Addresses: addresses}, err
+}
@@ -1,14 +1,32 @@
 package main
 
 import (
+	"math/rand"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// testFailsState is the FailsState with the unlimited content, unless the number of the items is set for the provider.
+type testFailsState struct {
+	fails     map[Provider]bool
+	available map[Provider]int
+}
+
+func (s testFailsState) Fails(p Provider) bool {
+	return s.fails[p]
+}
+
+func (s testFailsState) Available(p Provider) int {
+	if available, ok := s.available[p]; ok {
+		return available
+	}
+	return maxInt
+}
+
 func TestConfiguredSequencer_Sequence(t *testing.T) {
 	t.Run("normal sequencer functioning, offset 0", func(t *testing.T) {
-		state := &inMemoryState{fails: map[Provider]bool{Provider1: false, Provider2: false, Provider3: false}}
+		state := testFailsState{fails: map[Provider]bool{Provider1: false, Provider2: false, Provider3: false}}
 		config := DefaultConfig
 		sequencer := MakeConfiguredSequencer(config)
 		addresses, err := sequencer.Sequence(state, 8, 0)
@@ -26,7 +44,7 @@ func TestConfiguredSequencer_Sequence(t *testing.T) {
 		assert.Equal(t, expected, addresses)
 	})
 	t.Run("normal sequencer functioning, offset non 0", func(t *testing.T) {
-		state := &inMemoryState{fails: map[Provider]bool{Provider1: false, Provider2: false, Provider3: false}}
+		state := testFailsState{fails: map[Provider]bool{Provider1: false, Provider2: false, Provider3: false}}
 		config := DefaultConfig
 		sequencer := MakeConfiguredSequencer(config)
 		addresses, err := sequencer.Sequence(state, 8, 8)
@@ -44,7 +62,7 @@ func TestConfiguredSequencer_Sequence(t *testing.T) {
 		assert.Equal(t, expected, addresses)
 	})
 	t.Run("a provider fails", func(t *testing.T) {
-		state := &inMemoryState{fails: map[Provider]bool{Provider1: false, Provider2: true, Provider3: false}}
+		state := testFailsState{fails: map[Provider]bool{Provider1: false, Provider2: true, Provider3: false}}
 		config := DefaultConfig
 		sequencer := MakeConfiguredSequencer(config)
 		addresses, err := sequencer.Sequence(state, 8, 0)
@@ -62,7 +80,7 @@ func TestConfiguredSequencer_Sequence(t *testing.T) {
 		assert.Equal(t, expected, addresses)
 	})
 	t.Run("a provider fails, fallback fails", func(t *testing.T) {
-		state := &inMemoryState{fails: map[Provider]bool{Provider1: false, Provider2: true, Provider3: true}}
+		state := testFailsState{fails: map[Provider]bool{Provider1: false, Provider2: true, Provider3: true}}
 		config := DefaultConfig
 		sequencer := MakeConfiguredSequencer(config)
 		addresses, err := sequencer.Sequence(state, 8, 0)
@@ -74,7 +92,7 @@ func TestConfiguredSequencer_Sequence(t *testing.T) {
 		assert.Equal(t, expected, addresses)
 	})
 	t.Run("a provider fails, fallback nil", func(t *testing.T) {
-		state := &inMemoryState{fails: map[Provider]bool{Provider1: true, Provider2: false, Provider3: false}}
+		state := testFailsState{fails: map[Provider]bool{Provider1: true, Provider2: false, Provider3: false}}
 		config := DefaultConfig
 		sequencer := MakeConfiguredSequencer(config)
 		addresses, err := sequencer.Sequence(state, 8, 0)
@@ -88,7 +106,7 @@ func TestConfiguredSequencer_Sequence(t *testing.T) {
 		assert.Equal(t, expected, addresses)
 	})
 	t.Run("a provider fails, fallback fails, next page", func(t *testing.T) {
-		state := &inMemoryState{fails: map[Provider]bool{Provider1: false, Provider2: true, Provider3: true}}
+		state := testFailsState{fails: map[Provider]bool{Provider1: false, Provider2: true, Provider3: true}}
 		config := DefaultConfig
 		sequencer := MakeConfiguredSequencer(config)
 		addresses, err := sequencer.Sequence(state, 8, 8)
@@ -96,18 +114,461 @@ func TestConfiguredSequencer_Sequence(t *testing.T) {
 		expected := []ContentAddress{}
 		assert.Equal(t, expected, addresses)
 	})
+	t.Run("a provider is exhausted, fallback nil", func(t *testing.T) {
+		state := testFailsState{available: map[Provider]int{Provider1: 2}}
+		sequencer := MakeConfiguredSequencer(DefaultConfig)
+		addresses, err := sequencer.Sequence(state, 8, 0)
+		assert.NoError(t, err)
+		expected := []ContentAddress{
+			{Provider: Provider1, Index: 0},
+			{Provider: Provider1, Index: 1},
+			{Provider: Provider2, Index: 0},
+			{Provider: Provider3, Index: 0},
+		}
+		assert.Equal(t, expected, addresses)
+	})
+	t.Run("a provider is exhausted, fallback serves", func(t *testing.T) {
+		state := testFailsState{available: map[Provider]int{Provider2: 1}}
+		sequencer := MakeConfiguredSequencer(DefaultConfig)
+		addresses, err := sequencer.Sequence(state, 8, 0)
+		assert.NoError(t, err)
+		expected := []ContentAddress{
+			{Provider: Provider1, Index: 0},
+			{Provider: Provider1, Index: 1},
+			{Provider: Provider2, Index: 0},
+			{Provider: Provider3, Index: 0},
+			{Provider: Provider1, Index: 2},
+			{Provider: Provider1, Index: 3},
+			{Provider: Provider1, Index: 4},
+			{Provider: Provider3, Index: 1},
+		}
+		assert.Equal(t, expected, addresses)
+	})
+	t.Run("a provider is exhausted, fallback is exhausted", func(t *testing.T) {
+		state := testFailsState{available: map[Provider]int{Provider2: 1, Provider3: 1}}
+		sequencer := MakeConfiguredSequencer(DefaultConfig)
+		addresses, err := sequencer.Sequence(state, 8, 0)
+		assert.NoError(t, err)
+		expected := []ContentAddress{
+			{Provider: Provider1, Index: 0},
+			{Provider: Provider1, Index: 1},
+			{Provider: Provider2, Index: 0},
+			{Provider: Provider3, Index: 0},
+			{Provider: Provider1, Index: 2},
+			{Provider: Provider1, Index: 3},
+			{Provider: Provider1, Index: 4},
+		}
+		assert.Equal(t, expected, addresses)
+	})
+	t.Run("fallback chain", func(t *testing.T) {
+		state := testFailsState{
+			fails:     map[Provider]bool{Provider1: true},
+			available: map[Provider]int{Provider2: 1},
+		}
+		sequencer := MakeConfiguredSequencer(ContentMix{{Type: Provider1, Fallbacks: []Provider{Provider2, Provider3}}})
+		addresses, err := sequencer.Sequence(state, 3, 0)
+		assert.NoError(t, err)
+		expected := []ContentAddress{
+			{Provider: Provider2, Index: 0},
+			{Provider: Provider3, Index: 0},
+			{Provider: Provider3, Index: 1},
+		}
+		assert.Equal(t, expected, addresses)
+	})
+	t.Run("pinned positions", func(t *testing.T) {
+		sequencer := MakeConfiguredSequencer(ContentMix{{Type: Provider1}}, PinnedSlot{
+			ContentConfig: ContentConfig{Type: Provider2},
+			Positions:     []int{1, 4},
+		})
+		addresses, err := sequencer.Sequence(testFailsState{}, 6, 0)
+		assert.NoError(t, err)
+		expected := []ContentAddress{
+			{Provider: Provider1, Index: 0},
+			{Provider: Provider2, Index: 0},
+			{Provider: Provider1, Index: 1},
+			{Provider: Provider1, Index: 2},
+			{Provider: Provider2, Index: 1},
+			{Provider: Provider1, Index: 3},
+		}
+		assert.Equal(t, expected, addresses)
+	})
+	t.Run("pinned every n-th position, next page", func(t *testing.T) {
+		sequencer := MakeConfiguredSequencer(DefaultConfig, PinnedSlot{
+			ContentConfig: ContentConfig{Type: "4"},
+			Every:         3,
+		})
+		addresses, err := sequencer.Sequence(testFailsState{}, 6, 6)
+		assert.NoError(t, err)
+		expected := []ContentAddress{
+			{Provider: Provider1, Index: 2},
+			{Provider: Provider1, Index: 3},
+			{Provider: "4", Index: 2},
+			{Provider: Provider1, Index: 4},
+			{Provider: Provider2, Index: 1},
+			{Provider: "4", Index: 3},
+		}
+		assert.Equal(t, expected, addresses)
+	})
+	t.Run("too long pinned layout is rejected", func(t *testing.T) {
+		for name, pinned := range map[string][]PinnedSlot{
+			"long period":   {{ContentConfig: ContentConfig{Type: "4"}, Every: maxPinnedLayout/2 + 1}, {ContentConfig: ContentConfig{Type: "5"}, Every: maxPinnedLayout/2 + 3}},
+			"huge period":   {{ContentConfig: ContentConfig{Type: "4"}, Every: maxInt}},
+			"huge position": {{ContentConfig: ContentConfig{Type: "4"}, Positions: []int{maxInt}}},
+			"far positions": {{ContentConfig: ContentConfig{Type: "4"}, Positions: []int{maxPinnedLayout}}},
+		} {
+			pinned := pinned
+			t.Run(name, func(t *testing.T) {
+				sequencer := MakeConfiguredSequencer(DefaultConfig, pinned...)
+				_, err := sequencer.Sequence(testFailsState{}, 5, 0)
+				assert.Equal(t, errPinnedLayout, err)
+			})
+		}
+	})
+	t.Run("pinned provider fails, the slot is skipped", func(t *testing.T) {
+		state := testFailsState{fails: map[Provider]bool{"4": true}}
+		sequencer := MakeConfiguredSequencer(ContentMix{{Type: Provider1}}, PinnedSlot{
+			ContentConfig: ContentConfig{Type: "4"},
+			Every:         2,
+		})
+		addresses, err := sequencer.Sequence(state, 3, 3)
+		assert.NoError(t, err)
+		expected := []ContentAddress{
+			{Provider: Provider1, Index: 3},
+			{Provider: Provider1, Index: 4},
+			{Provider: Provider1, Index: 5},
+		}
+		assert.Equal(t, expected, addresses)
+	})
+	t.Run("failed slot is skipped", func(t *testing.T) {
+		state := testFailsState{fails: map[Provider]bool{Provider2: true, Provider3: true}}
+		mix := ContentMix{{Type: Provider1}, {Type: Provider2, Fallback: &Provider3, OnFailure: PolicySkip}}
+		page, err := MakeConfiguredSequencer(mix).SequencePage(state, 3, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, Page{
+			Addresses: []ContentAddress{
+				{Provider: Provider1, Index: 2},
+				{Provider: Provider1, Index: 3},
+				{Provider: Provider1, Index: 4},
+			},
+			Failures: []SlotFailure{
+				{Provider: Provider2, Policy: PolicySkip},
+				{Provider: Provider2, Policy: PolicySkip},
+			},
+		}, page)
+	})
+	t.Run("failed slot is replaced with the placeholder", func(t *testing.T) {
+		state := testFailsState{fails: map[Provider]bool{Provider2: true}}
+		mix := ContentMix{{Type: Provider1}, {Type: Provider2, OnFailure: PolicyPlaceholder}}
+		page, err := MakeConfiguredSequencer(mix).SequencePage(state, 3, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, Page{
+			Addresses: []ContentAddress{
+				{Provider: Provider1, Index: 1},
+				{Provider: Provider2, Placeholder: true},
+				{Provider: Provider1, Index: 2},
+			},
+			Failures: []SlotFailure{{Provider: Provider2, Policy: PolicyPlaceholder}},
+		}, page)
+	})
+	t.Run("placeholders end with the content", func(t *testing.T) {
+		state := testFailsState{fails: map[Provider]bool{Provider2: true}, available: map[Provider]int{Provider1: 2}}
+		mix := ContentMix{{Type: Provider1}, {Type: Provider2, OnFailure: PolicyPlaceholder}}
+		addresses, err := MakeConfiguredSequencer(mix).Sequence(state, 5000000, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, []ContentAddress{
+			{Provider: Provider1, Index: 0},
+			{Provider: Provider2, Placeholder: true},
+			{Provider: Provider1, Index: 1},
+		}, addresses)
+		addresses, err = MakeConfiguredSequencer(mix).Sequence(state, 10, 5000000)
+		assert.NoError(t, err)
+		assert.Empty(t, addresses)
+	})
+	t.Run("truncation is reported", func(t *testing.T) {
+		state := testFailsState{fails: map[Provider]bool{Provider2: true, Provider3: true}}
+		page, err := MakeConfiguredSequencer(DefaultConfig).SequencePage(state, 8, 8)
+		assert.NoError(t, err)
+		assert.Equal(t, Page{
+			Addresses: []ContentAddress{},
+			Failures:  []SlotFailure{{Provider: Provider2, Policy: PolicyTruncate}},
+		}, page)
+	})
+	t.Run("end of the content is not reported", func(t *testing.T) {
+		state := testFailsState{available: map[Provider]int{Provider1: 2, "4": 0}}
+		sequencer := MakeConfiguredSequencer(ContentMix{{Type: Provider1}}, PinnedSlot{
+			ContentConfig: ContentConfig{Type: "4"},
+			Every:         2,
+		})
+		page, err := sequencer.SequencePage(state, 5, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, Page{
+			Addresses: []ContentAddress{
+				{Provider: Provider1, Index: 0},
+				{Provider: Provider1, Index: 1},
+			},
+		}, page)
+	})
+	t.Run("substitutions are reported", func(t *testing.T) {
+		state := testFailsState{fails: map[Provider]bool{Provider1: true}}
+		mix := ContentMix{config1, config2}
+		page, err := MakeConfiguredSequencer(mix).SequencePage(state, 3, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, Page{
+			Addresses: []ContentAddress{
+				{Provider: Provider2, Index: 1},
+				{Provider: Provider2, Index: 2},
+				{Provider: Provider2, Index: 3},
+			},
+			Substitutions: []Substitution{
+				{Provider: Provider1, Fallback: Provider2},
+			},
+		}, page)
+	})
 	t.Run("incorrect limit error", func(t *testing.T) {
-		state := &inMemoryState{fails: map[Provider]bool{Provider1: false, Provider2: false, Provider3: false}}
+		state := testFailsState{fails: map[Provider]bool{Provider1: false, Provider2: false, Provider3: false}}
 		config := DefaultConfig
 		sequencer := MakeConfiguredSequencer(config)
 		_, err := sequencer.Sequence(state, -1, 8)
 		assert.Error(t, err)
 	})
 	t.Run("incorrect offset error", func(t *testing.T) {
-		state := &inMemoryState{fails: map[Provider]bool{Provider1: false, Provider2: false, Provider3: false}}
+		state := testFailsState{fails: map[Provider]bool{Provider1: false, Provider2: false, Provider3: false}}
 		config := DefaultConfig
 		sequencer := MakeConfiguredSequencer(config)
 		_, err := sequencer.Sequence(state, -1, 8)
 		assert.Error(t, err)
 	})
 }
+
+func TestSwappableSequencer_Sequence(t *testing.T) {
+	state := testFailsState{fails: map[Provider]bool{}}
+	sequencer := NewSwappableSequencer(MakeConfiguredSequencer(ContentMix{{Type: Provider1}}))
+	addresses, err := sequencer.Sequence(state, 2, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []ContentAddress{{Provider: Provider1, Index: 0}, {Provider: Provider1, Index: 1}}, addresses)
+
+	sequencer.Swap(MakeConfiguredSequencer(ContentMix{{Type: Provider2}}))
+	addresses, err = sequencer.Sequence(state, 2, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []ContentAddress{{Provider: Provider2, Index: 0}, {Provider: Provider2, Index: 1}}, addresses)
+}
+
+// iterativeSequence is the reference implementation of the ConfiguredSequencer walking the mix from the very start.
+func iterativeSequence(config ContentMix, pinned []PinnedSlot, state FailsState, limit, offset int) []ContentAddress {
+	providersIndex := make(map[Provider]int, len(config))
+	addresses := make([]ContentAddress, 0, limit)
+	cycleStart, cycleProviders := iterativeCycle(config, pinned)
+	served, mixed, lastServed := 0, 0, 0
+	for position := 0; served < offset+limit && position-lastServed < 1000; position++ {
+		slot, isPinned := iterativeSlot(config, pinned, position, mixed)
+		if !isPinned {
+			mixed++
+		}
+		provider, ok := iterativeResolve(slot, state, providersIndex)
+		if !ok {
+			switch slot.OnFailure {
+			case PolicySkip:
+				continue
+			case PolicyPlaceholder:
+				if position >= cycleStart && !anyServes(cycleProviders, state, providersIndex) {
+					return addresses
+				}
+				if served >= offset {
+					addresses = append(addresses, ContentAddress{Provider: slot.Type, Placeholder: true})
+				}
+				served++
+				lastServed = position
+				continue
+			default:
+				return addresses
+			}
+		}
+		if served >= offset {
+			addresses = append(addresses, ContentAddress{Provider: provider, Index: providersIndex[provider]})
+		}
+		providersIndex[provider]++
+		served++
+		lastServed = position
+	}
+	return addresses
+}
+
+// iterativeSlot returns the slot at the position and if it is pinned, mixed is the number of the mix slots before it.
+func iterativeSlot(config ContentMix, pinned []PinnedSlot, position, mixed int) (ContentConfig, bool) {
+	for _, ps := range pinned {
+		isPinned := ps.Every > 0 && (position+1)%ps.Every == 0
+		for _, p := range ps.Positions {
+			isPinned = isPinned || p == position
+		}
+		if isPinned {
+			slot := ps.ContentConfig
+			if slot.OnFailure == "" {
+				slot.OnFailure = PolicySkip
+			}
+			return slot, true
+		}
+	}
+	return config[mixed%len(config)], false
+}
+
+func iterativeResolve(slot ContentConfig, state FailsState, providersIndex map[Provider]int) (Provider, bool) {
+	for _, p := range slot.chain() {
+		if !state.Fails(p) && providersIndex[p] < state.Available(p) {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// iterativeCycle returns the position the slots start repeating at, after the absolute pinned positions,
+// and the providers of the slots repeating after it.
+func iterativeCycle(config ContentMix, pinned []PinnedSlot) (int, []Provider) {
+	start := 0
+	for _, ps := range pinned {
+		for _, p := range ps.Positions {
+			if p >= start {
+				start = p + 1
+			}
+		}
+	}
+	providers := make([]Provider, 0)
+	for position, mixed := 0, 0; position < start+1000; position++ {
+		slot, isPinned := iterativeSlot(config, pinned, position, mixed)
+		if !isPinned {
+			mixed++
+		}
+		if position >= start {
+			for _, p := range slot.chain() {
+				providers = appendProvider(providers, p)
+			}
+		}
+	}
+	return start, providers
+}
+
+func randomMix(rnd *rand.Rand, providers []Provider) ContentMix {
+	mix := make(ContentMix, 1+rnd.Intn(10))
+	for i := range mix {
+		mix[i].Type = providers[rnd.Intn(len(providers))]
+		if rnd.Intn(2) == 0 {
+			fallback := providers[rnd.Intn(len(providers))]
+			mix[i].Fallback = &fallback
+		}
+		for j := rnd.Intn(3); j > 0; j-- {
+			mix[i].Fallbacks = append(mix[i].Fallbacks, providers[rnd.Intn(len(providers))])
+		}
+		if rnd.Intn(4) == 0 {
+			mix[i].OnFailure = []FailurePolicy{PolicyTruncate, PolicySkip, PolicyPlaceholder}[rnd.Intn(3)]
+		}
+	}
+	return mix
+}
+
+func randomPinned(rnd *rand.Rand, providers []Provider) []PinnedSlot {
+	pinned := make([]PinnedSlot, rnd.Intn(3))
+	for i := range pinned {
+		pinned[i].ContentConfig = randomMix(rnd, providers)[0]
+		if rnd.Intn(2) == 0 {
+			pinned[i].Every = 2 + rnd.Intn(5)
+		}
+		for j := rnd.Intn(3); j > 0; j-- {
+			pinned[i].Positions = append(pinned[i].Positions, rnd.Intn(30))
+		}
+		if pinned[i].Every == 0 && len(pinned[i].Positions) == 0 {
+			pinned[i].Every = 3
+		}
+	}
+	return pinned
+}
+
+func randomState(rnd *rand.Rand, providers []Provider) testFailsState {
+	state := testFailsState{
+		fails:     make(map[Provider]bool, len(providers)),
+		available: make(map[Provider]int, len(providers)),
+	}
+	for _, p := range providers {
+		state.fails[p] = rnd.Intn(4) == 0
+		if rnd.Intn(3) == 0 {
+			state.available[p] = rnd.Intn(100)
+		}
+	}
+	return state
+}
+
+func TestConfiguredSequencer_SequenceMatchesIterative(t *testing.T) {
+	providers := []Provider{Provider1, Provider2, Provider3, "4"}
+	rnd := rand.New(rand.NewSource(42))
+	for n := 0; n < 2000; n++ {
+		mix := randomMix(rnd, providers)
+		state := randomState(rnd, providers)
+		limit, offset := rnd.Intn(30), rnd.Intn(200)
+		addresses, err := MakeConfiguredSequencer(mix).Sequence(state, limit, offset)
+		assert.NoError(t, err)
+		if !assert.Equal(t, iterativeSequence(mix, nil, state, limit, offset), addresses) {
+			t.Fatalf("mix %+v, fails %v, available %v, limit %d, offset %d", mix, state.fails, state.available, limit, offset)
+		}
+	}
+}
+
+func TestConfiguredSequencer_SequencePinnedMatchesIterative(t *testing.T) {
+	providers := []Provider{Provider1, Provider2, Provider3, "4"}
+	rnd := rand.New(rand.NewSource(42))
+	for n := 0; n < 2000; n++ {
+		mix, pinned := randomMix(rnd, providers), randomPinned(rnd, providers)
+		state := randomState(rnd, providers)
+		limit, offset := rnd.Intn(30), rnd.Intn(300)
+		addresses, err := MakeConfiguredSequencer(mix, pinned...).Sequence(state, limit, offset)
+		assert.NoError(t, err)
+		if !assert.Equal(t, iterativeSequence(mix, pinned, state, limit, offset), addresses) {
+			t.Fatalf("mix %+v, pinned %+v, fails %v, available %v, limit %d, offset %d",
+				mix, pinned, state.fails, state.available, limit, offset)
+		}
+	}
+}
+
+func TestConfiguredSequencer_SequenceDeepOffset(t *testing.T) {
+	t.Run("index is computed from the full cycles", func(t *testing.T) {
+		state := testFailsState{fails: map[Provider]bool{}}
+		addresses, err := MakeConfiguredSequencer(DefaultConfig).Sequence(state, 3, 8*1000000000+2)
+		assert.NoError(t, err)
+		assert.Equal(t, []ContentAddress{
+			{Provider: Provider2, Index: 2 * 1000000000},
+			{Provider: Provider3, Index: 1000000000},
+			{Provider: Provider1, Index: 5*1000000000 + 2},
+		}, addresses)
+	})
+	t.Run("provider is exhausted before the offset", func(t *testing.T) {
+		state := testFailsState{available: map[Provider]int{Provider2: 1000}}
+		addresses, err := MakeConfiguredSequencer(DefaultConfig).Sequence(state, 3, 8*1000000000+2)
+		assert.NoError(t, err)
+		assert.Equal(t, []ContentAddress{
+			{Provider: Provider3, Index: 3*1000000000 - 1000},
+			{Provider: Provider3, Index: 3*1000000000 - 1000 + 1},
+			{Provider: Provider1, Index: 5*1000000000 + 2},
+		}, addresses)
+	})
+	t.Run("pinned slots", func(t *testing.T) {
+		mix := ContentMix{{Type: Provider1, Fallback: &Provider2}, {Type: Provider2}, {Type: Provider3}}
+		pinned := []PinnedSlot{
+			{ContentConfig: ContentConfig{Type: "4"}, Every: 10, Positions: []int{2}},
+			{ContentConfig: ContentConfig{Type: Provider3}, Every: 4},
+		}
+		state := testFailsState{available: map[Provider]int{Provider1: 5000, "4": 1000}}
+		addresses, err := MakeConfiguredSequencer(mix, pinned...).Sequence(state, 20, 100000)
+		assert.NoError(t, err)
+		assert.Equal(t, iterativeSequence(mix, pinned, state, 20, 100000), addresses)
+	})
+	t.Run("offset overflow", func(t *testing.T) {
+		state := testFailsState{fails: map[Provider]bool{}}
+		_, err := MakeConfiguredSequencer(DefaultConfig).Sequence(state, 10, maxInt-5)
+		assert.Error(t, err)
+	})
+}
+
+func BenchmarkConfiguredSequencer_SequenceDeepOffset(b *testing.B) {
+	state := testFailsState{fails: map[Provider]bool{Provider2: true}}
+	sequencer := MakeConfiguredSequencer(DefaultConfig)
+	for i := 0; i < b.N; i++ {
+		_, _ = sequencer.Sequence(state, 10, 10000000)
+	}
+}
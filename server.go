@@ -4,34 +4,136 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 )
 
 // App represents the server's internal state.
 // It holds configuration about providers and content
 type App struct {
 	Service Service
+	// Reloader reloads the configuration, its endpoint is served by the AdminHandler only, it is disabled if nil.
+	Reloader *Reloader
+	// Readiness serves the readiness endpoint, the endpoint is disabled if nil.
+	Readiness ReadinessReporter
+	// Status serves the status endpoint of the providers, the endpoint is disabled if nil.
+	Status StatusReporter
+	// Metrics counts the requests by the status code and serves the metrics endpoint, the endpoint is disabled if nil.
+	Metrics *Metrics
+	// DisableRootAlias stops serving the content at the root path, the content is served at the versioned path only.
+	DisableRootAlias bool
 }
 
+const reloadPath = "/admin/reload"
+
+// nextCursorHeader is the cursor of the next page, passed in the "cursor" parameter it returns the next page
+// of the same state of the content, even if the content has been refreshed since.
+const nextCursorHeader = "X-Next-Cursor"
+
+// failurePolicyHeader lists the failure policies applied to the slots of the page which could not be served,
+// e.g. "truncate" tells the page is short because of the failed provider, not because the content ended.
+const failurePolicyHeader = "X-Failure-Policy"
+
 func (a App) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	log.Printf("%s %s", req.Method, req.URL.String())
-	limit, offset, err := getParameters(w, req)
+	a.serve(w, req, a.routes())
+}
+
+// AdminHandler serves the admin endpoints, it is meant for the listener apart from the content one,
+// which is not reachable publicly.
+func (a App) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		a.serve(w, req, a.adminRoutes())
+	})
+}
+
+// serve dispatches the request to the routes with the request ID and counts it in the metrics.
+func (a App) serve(w http.ResponseWriter, req *http.Request, routes router) {
+	id := requestID(req)
+	w.Header().Set(requestIDHeader, id)
+	log.Printf("%s %s %s", id, req.Method, req.URL.String())
+	if a.Metrics != nil {
+		recorder := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			a.Metrics.observeRequest(recorder.status())
+		}()
+		w = recorder
+	}
+	routes.ServeHTTP(w, req)
+}
+
+// routes returns the endpoints of the app, the optional ones are left out if disabled.
+func (a App) routes() router {
+	routes := router{
+		{path: contentPath, methods: []string{http.MethodGet}, handler: a.serveContent},
+		{path: contentV2Path, methods: []string{http.MethodGet}, handler: a.serveContentV2},
+		{path: healthPath, methods: []string{http.MethodGet}, handler: func(w http.ResponseWriter, _ *http.Request) {
+			writeHealthResponse(w)
+		}},
+	}
+	if !a.DisableRootAlias {
+		routes = append(routes, route{path: rootPath, methods: []string{http.MethodGet}, handler: a.serveContent})
+	}
+	if a.Readiness != nil {
+		routes = append(routes, route{path: readyPath, methods: []string{http.MethodGet},
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				writeReadinessResponse(w, a.Readiness.Readiness())
+			}})
+	}
+	if a.Status != nil {
+		routes = append(routes, route{path: providersStatusPath, methods: []string{http.MethodGet},
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				writeProvidersStatusResponse(w, a.Status.ProvidersStatus())
+			}})
+	}
+	if a.Metrics != nil {
+		routes = append(routes, route{path: metricsPath, methods: []string{http.MethodGet}, handler: a.Metrics.ServeHTTP})
+	}
+	return routes
+}
+
+// adminRoutes returns the admin endpoints, the optional ones are left out if disabled.
+func (a App) adminRoutes() router {
+	var routes router
+	if a.Reloader != nil {
+		routes = append(routes, route{path: reloadPath, methods: []string{http.MethodPost}, handler: a.Reloader.ServeHTTP})
+	}
+	return routes
+}
+
+// contentPage returns the page of the content items the request asks for, and the requested count.
+func (a App) contentPage(req *http.Request) (page ContentPage, limit int, err error) {
+	limit, offset, err := getParameters(req)
 	if err != nil {
-		writeValidationErrorResponse(w, err)
+		return
 	}
-	items, err := a.Service.ContentItems(limit, offset)
+	if cursor := req.URL.Query().Get("cursor"); cursor != "" {
+		page, err = a.Service.ContentPageAt(cursor, limit)
+	} else {
+		page, err = a.Service.ContentPage(limit, offset)
+	}
+	return
+}
+
+// serveContent serves the page of the content items as the bare array, the next cursor and the failure policies
+// are in the headers.
+func (a App) serveContent(w http.ResponseWriter, req *http.Request) {
+	page, _, err := a.contentPage(req)
 	if err != nil {
-		if _, ok := err.(ValidationError); ok {
-			writeValidationErrorResponse(w, err)
-		} else {
-			writeInternalServerErrorResponse(w, err)
-		}
+		writeErrorResponse(w, err)
+		return
 	}
-	bb, err := json.Marshal(items)
+	bb, err := json.Marshal(page.Items)
 	if err != nil {
-		writeInternalServerErrorResponse(w, err)
+		writeErrorResponse(w, err)
 		return
 	}
+	if page.NextCursor != "" {
+		w.Header().Set(nextCursorHeader, page.NextCursor)
+	}
+	if policies := page.Policies(); len(policies) != 0 {
+		w.Header().Set(failurePolicyHeader, joinPolicies(policies))
+	}
 	w.WriteHeader(http.StatusOK)
 	if _, err := w.Write(bb); err != nil {
 		log.Println("error when trying to write data to HTTP response: " + err.Error())
@@ -39,37 +141,64 @@ func (a App) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	return
 }
 
-func getParameters(w http.ResponseWriter, req *http.Request) (limit, offset int, err error) {
-	limits, _ := req.URL.Query()["count"]
-	if len(limits) != 0 {
-		limit, err = strconv.Atoi(limits[0])
-		if err != nil {
-			return
-		}
+// statusRecorder remembers the status code of the response.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if sr.code == 0 {
+		sr.code = code
 	}
+	sr.ResponseWriter.WriteHeader(code)
+}
 
-	offsets, _ := req.URL.Query()["offset"]
-	if len(offsets) != 0 {
-		offset, err = strconv.Atoi(offsets[0])
-		if err != nil {
-			return
-		}
+func (sr *statusRecorder) Write(bb []byte) (int, error) {
+	if sr.code == 0 {
+		sr.code = http.StatusOK
 	}
-	return
+	return sr.ResponseWriter.Write(bb)
 }
 
-func writeInternalServerErrorResponse(w http.ResponseWriter, err error) {
-	log.Print("internal server error: " + err.Error())
-	w.WriteHeader(http.StatusInternalServerError)
-	if _, err := w.Write([]byte("internal server error: " + err.Error())); err != nil {
-		log.Println("error when trying to write data to HTTP response: " + err.Error())
+// status returns the status code of the response, 200 if the handler has not written anything.
+func (sr *statusRecorder) status() int {
+	if sr.code == 0 {
+		return http.StatusOK
 	}
+	return sr.code
 }
 
-func writeValidationErrorResponse(w http.ResponseWriter, err error) {
-	log.Print("validation error: " + err.Error())
-	w.WriteHeader(http.StatusBadRequest)
-	if _, err := w.Write([]byte("invalid input parameters: " + err.Error())); err != nil {
-		log.Println("error when trying to write data to HTTP response: " + err.Error())
+func joinPolicies(policies []FailurePolicy) string {
+	ss := make([]string, len(policies))
+	for i, policy := range policies {
+		ss[i] = string(policy)
+	}
+	return strings.Join(ss, ", ")
+}
+
+// getParameters returns the count and the offset of the request, 0 if not given.
+func getParameters(req *http.Request) (limit, offset int, err error) {
+	query := req.URL.Query()
+	if limit, err = intParameter(query, "count"); err != nil {
+		return
+	}
+	offset, err = intParameter(query, "offset")
+	return
+}
+
+// intParameter returns the non-negative integer parameter of the query, 0 if not given.
+func intParameter(query url.Values, name string) (int, error) {
+	values := query[name]
+	if len(values) == 0 {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(values[0])
+	if err != nil {
+		return 0, ParameterError{Parameter: name, Reason: "should be an integer"}
+	}
+	if n < 0 {
+		return 0, ParameterError{Parameter: name, Reason: "should not be negative"}
 	}
+	return n, nil
 }
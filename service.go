@@ -3,26 +3,36 @@ package main
 import (
 	"fmt"
 	"log"
+	"time"
 )
 
-type ValidationError string
-
-func (ve ValidationError) Error() string {
-	return string(ve)
-}
-
 // Service the service to provide the data for the given config.
 type Service struct {
 	cacher    Cacher
 	sequencer Sequencer
+	metrics   *Metrics
+}
+
+// ServiceOption customises the Service on construction.
+type ServiceOption func(*Service)
+
+// WithServiceMetrics makes the Service record the latency of the pages, the short pages and the fallback substitutions.
+func WithServiceMetrics(metrics *Metrics) ServiceOption {
+	return func(s *Service) {
+		s.metrics = metrics
+	}
 }
 
 // MakeService is a constructor for the Service, it has the checher component and the sequencer component as the input.
-func MakeService(cacher Cacher, sequencer Sequencer) Service {
-	return Service{
+func MakeService(cacher Cacher, sequencer Sequencer, opts ...ServiceOption) Service {
+	s := Service{
 		cacher:    cacher,
 		sequencer: sequencer,
 	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	return s
 }
 
 // Cacher is responsible for keeping the state and providing it to the service on request.
@@ -30,21 +40,35 @@ type Cacher interface {
 	GetState() State
 }
 
+// SnapshotCacher is the Cacher keeping the recent states for a while after they are replaced,
+// so all the pages of a listing can be served from the state the listing started with.
+type SnapshotCacher interface {
+	GetSnapshot(version uint64) (State, bool)
+}
+
 // State keeps the desired content iteems and information about the provider health.
 type State interface {
 	FailsState
 	ContentItem(addr ContentAddress) *ContentItem
+	ProviderStatus(p Provider) ProviderStatus
+	Health(p Provider) ProviderHealth
+	// Version identifies the state, the cursors point to the pages of the state of their version.
+	Version() uint64
 }
 
-// FailsState keeps the information about the provider health.
+// FailsState keeps the information about the provider health and the number of the items it has.
 type FailsState interface {
 	Fails(p Provider) bool
+	// Available returns the number of the items cached for the provider, the indexes from 0 to Available-1 are served.
+	Available(p Provider) int
 }
 
 // ContentAddress contains the information about the provider and the index of the data.
 type ContentAddress struct {
 	Provider Provider
 	Index    int
+	// Placeholder address stands for the slot of the provider which could not be served, it has no index.
+	Placeholder bool
 }
 
 // Sequencer makes the sequence of provider+index for the given input page.
@@ -52,8 +76,102 @@ type Sequencer interface {
 	Sequence(state FailsState, limit, offset int) ([]ContentAddress, error)
 }
 
+// LayoutSequencer is the Sequencer which layout may change, e.g. when the configuration is reloaded.
+// The cursors made with another layout version have expired, so the listing does not go on with the different layout.
+type LayoutSequencer interface {
+	LayoutVersion() uint64
+}
+
+// PageSequencer is the Sequencer reporting the slots of the page which could not be served.
+type PageSequencer interface {
+	SequencePage(state FailsState, limit, offset int) (Page, error)
+}
+
+// Page is the sequence of provider+index of the page, the slots of the page which could not be served,
+// and the slots served by the fallbacks.
+type Page struct {
+	Addresses     []ContentAddress
+	Failures      []SlotFailure
+	Substitutions []Substitution
+}
+
+// Substitution is the slot of the provider served by its fallback.
+type Substitution struct {
+	Provider Provider
+	Fallback Provider
+}
+
+// SlotFailure is the slot which neither the provider nor its fallbacks could serve, and the policy applied to it.
+type SlotFailure struct {
+	Provider Provider
+	Policy   FailurePolicy
+}
+
+// ContentPage is the page of the content items, and the slots of the page which could not be served.
+type ContentPage struct {
+	Items    []*ContentItem
+	Failures []SlotFailure
+	// Offset is the offset of the page, the one of the cursor for the page at the cursor.
+	Offset int
+	// Providers are the providers which items the page has, in the order of their first items.
+	Providers []Provider
+	// TruncatedReason tells why the page is cut short before the content ended, e.g. "provider_failed:2"
+	// for the slot of the failing provider 2, it is empty if the page is not.
+	TruncatedReason string
+	// NextCursor points to the next page of the same state, it is empty if the page is the last one.
+	NextCursor string
+}
+
+// Policies returns the failure policies applied to the page, every policy once.
+func (cp ContentPage) Policies() []FailurePolicy {
+	var policies []FailurePolicy
+	for _, failure := range cp.Failures {
+		applied := false
+		for _, policy := range policies {
+			applied = applied || policy == failure.Policy
+		}
+		if !applied {
+			policies = append(policies, failure.Policy)
+		}
+	}
+	return policies
+}
+
 // ContentItems returns the desired content items.
-func (s Service) ContentItems(limit, offset int) (output []*ContentItem, err error) {
+func (s Service) ContentItems(limit, offset int) ([]*ContentItem, error) {
+	page, err := s.ContentPage(limit, offset)
+	return page.Items, err
+}
+
+// ContentPage returns the desired content items of the current state, and the slots of the page which could not be served
+// if the sequencer reports them.
+func (s Service) ContentPage(limit, offset int) (ContentPage, error) {
+	return s.contentPage(s.cacher.GetState(), limit, offset)
+}
+
+// ContentPageAt returns the page the cursor points to from the state the listing started with,
+// or ErrCursorExpired if the state is not kept anymore or the layout of the sequencer has changed since.
+func (s Service) ContentPageAt(cursor string, limit int) (ContentPage, error) {
+	c, err := ParseCursor(cursor)
+	if err != nil {
+		return ContentPage{}, err
+	}
+	state, ok := s.snapshot(c.Version)
+	if !ok || c.Layout != s.layoutVersion() {
+		return ContentPage{}, ErrCursorExpired
+	}
+	return s.contentPage(state, limit, c.Offset)
+}
+
+func (s Service) snapshot(version uint64) (State, bool) {
+	if sc, ok := s.cacher.(SnapshotCacher); ok {
+		return sc.GetSnapshot(version)
+	}
+	state := s.cacher.GetState()
+	return state, state.Version() == version
+}
+
+func (s Service) contentPage(state State, limit, offset int) (output ContentPage, err error) {
 	log.Print(fmt.Sprintf("called ContentItems with parameters limit=%d, offset=%d", limit, offset))
 	defer log.Print(fmt.Sprintf("finished ContentItems with parameters limit=%d, offset=%d, error: %s",
 		limit, offset, err))
@@ -62,17 +180,70 @@ func (s Service) ContentItems(limit, offset int) (output []*ContentItem, err err
 		err = ValidationError("limit and offset should be positive")
 		return
 	}
-	output = make([]*ContentItem, 0, limit)
-	state := s.cacher.GetState()
-	addressSequence, err := s.sequencer.Sequence(state, limit, offset)
+	started := time.Now()
+	// the layout version is taken before the page is made, so the page made with a newer layout has the expired cursor
+	layout := s.layoutVersion()
+	page, err := s.sequence(state, limit, offset)
 	if err != nil {
-		return nil, err
+		return ContentPage{}, err
+	}
+	defer func() {
+		s.metrics.observePage(time.Since(started), page, state)
+	}()
+	output.Items = make([]*ContentItem, 0, len(page.Addresses))
+	output.Failures = page.Failures
+	output.Offset = offset
+	output.TruncatedReason = truncatedReason(state, page.Failures)
+	if limit > 0 && len(page.Addresses) == limit {
+		output.NextCursor = Cursor{Version: state.Version(), Layout: layout, Offset: offset + limit}.String()
 	}
-	for _, address := range addressSequence {
+	for _, address := range page.Addresses {
+		if address.Placeholder {
+			output.Items = append(output.Items, &ContentItem{Source: string(address.Provider), Type: PlaceholderItemType})
+			continue
+		}
 		ci := state.ContentItem(address)
 		if ci != nil {
-			output = append(output, state.ContentItem(address))
+			output.Items = append(output.Items, ci)
+			output.Providers = appendProvider(output.Providers, address.Provider)
 		}
 	}
 	return
 }
+
+// truncatedReason returns the reason of the truncation by the failing provider. The truncation by the provider
+// which has run out of the items is the end of the content, not reported.
+func truncatedReason(state FailsState, failures []SlotFailure) string {
+	for _, failure := range failures {
+		if failure.Policy == PolicyTruncate && state.Fails(failure.Provider) {
+			return "provider_failed:" + string(failure.Provider)
+		}
+	}
+	return ""
+}
+
+// appendProvider appends the provider unless the providers have it already.
+func appendProvider(providers []Provider, p Provider) []Provider {
+	for _, provider := range providers {
+		if provider == p {
+			return providers
+		}
+	}
+	return append(providers, p)
+}
+
+// layoutVersion returns the layout version of the sequencer, 0 if its layout does not change.
+func (s Service) layoutVersion() uint64 {
+	if ls, ok := s.sequencer.(LayoutSequencer); ok {
+		return ls.LayoutVersion()
+	}
+	return 0
+}
+
+func (s Service) sequence(state FailsState, limit, offset int) (Page, error) {
+	if ps, ok := s.sequencer.(PageSequencer); ok {
+		return ps.SequencePage(state, limit, offset)
+	}
+	addresses, err := s.sequencer.Sequence(state, limit, offset)
+	return Page{Addresses: addresses}, err
+}
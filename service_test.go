@@ -10,6 +10,7 @@ import (
 
 type testSequencer struct {
 	addresses []ContentAddress
+	failures  []SlotFailure
 	err       error
 }
 
@@ -17,6 +18,10 @@ func (t testSequencer) Sequence(state FailsState, limit, offset int) ([]ContentA
 	return t.addresses, t.err
 }
 
+func (t testSequencer) SequencePage(state FailsState, limit, offset int) (Page, error) {
+	return Page{Addresses: t.addresses, Failures: t.failures}, t.err
+}
+
 type testCacher struct {
 	state State
 }
@@ -83,6 +88,26 @@ func TestService_ContentItems(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, string(expected), string(actual))
 	})
+	t.Run("placeholder of the failed slot", func(t *testing.T) {
+		s := testSequencer{
+			addresses: []ContentAddress{
+				{Provider: "p1", Index: 0},
+				{Provider: "p2", Placeholder: true},
+			},
+			failures: []SlotFailure{{Provider: "p2", Policy: PolicyPlaceholder}},
+		}
+		c := testCacher{state: &inMemoryState{
+			content: map[Provider][]*ContentItem{"p1": {&ContentItem{ID: "p1-0"}}},
+		}}
+		page, err := MakeService(c, s).ContentPage(10, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, ContentPage{
+			Items:     []*ContentItem{{ID: "p1-0"}, {Source: "p2", Type: PlaceholderItemType}},
+			Failures:  []SlotFailure{{Provider: "p2", Policy: PolicyPlaceholder}},
+			Providers: []Provider{"p1"},
+		}, page)
+		assert.Equal(t, []FailurePolicy{PolicyPlaceholder}, page.Policies())
+	})
 	t.Run("sequencer returns error", func(t *testing.T) {
 		s := testSequencer{
 			addresses: nil,
@@ -124,3 +149,37 @@ func TestService_ContentItems(t *testing.T) {
 		assert.Error(t, err)
 	})
 }
+
+func TestService_ContentPageAt(t *testing.T) {
+	state := &inMemoryState{
+		content: map[Provider][]*ContentItem{"p1": {{ID: "p1-0"}, {ID: "p1-1"}, {ID: "p1-2"}}},
+		version: 7,
+	}
+	service := MakeService(testCacher{state: state}, MakeConfiguredSequencer(ContentMix{{Type: "p1"}}))
+	t.Run("cursor points to the next page", func(t *testing.T) {
+		page, err := service.ContentPage(2, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, Cursor{Version: 7, Offset: 2}.String(), page.NextCursor)
+		page, err = service.ContentPageAt(page.NextCursor, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, []*ContentItem{{ID: "p1-2"}}, page.Items)
+		assert.Empty(t, page.NextCursor)
+	})
+	t.Run("cursor of another state has expired", func(t *testing.T) {
+		_, err := service.ContentPageAt(Cursor{Version: 6, Offset: 2}.String(), 2)
+		assert.Equal(t, ErrCursorExpired, err)
+	})
+	t.Run("cursor of another layout has expired", func(t *testing.T) {
+		sequencer := NewSwappableSequencer(MakeConfiguredSequencer(ContentMix{{Type: "p1"}}))
+		service := MakeService(testCacher{state: state}, sequencer)
+		page, err := service.ContentPage(1, 0)
+		assert.NoError(t, err)
+		sequencer.Swap(MakeConfiguredSequencer(ContentMix{{Type: "p1"}, {Type: "p1"}}))
+		_, err = service.ContentPageAt(page.NextCursor, 1)
+		assert.Equal(t, ErrCursorExpired, err)
+	})
+	t.Run("invalid cursor", func(t *testing.T) {
+		_, err := service.ContentPageAt("???", 2)
+		assert.Equal(t, errInvalidCursor, err)
+	})
+}
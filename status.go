@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	healthPath          = "/healthz"
+	providersStatusPath = "/status/providers"
+)
+
+// ProviderReport is the status of a provider as reported on the status endpoint.
+type ProviderReport struct {
+	Fails     bool              `json:"fails"`
+	Health    ProviderHealth    `json:"health"`
+	Readiness ProviderReadiness `json:"readiness"`
+	// Items is the number of the items cached for the provider.
+	Items int `json:"items"`
+	// Failures is the number of consecutive failed refreshes.
+	Failures    int        `json:"failures"`
+	LastSuccess *time.Time `json:"last_success"`
+	LastError   string     `json:"last_error,omitempty"`
+	NextRefresh *time.Time `json:"next_refresh"`
+}
+
+// ProvidersStatus is the body of the status endpoint.
+type ProvidersStatus struct {
+	Providers map[Provider]ProviderReport `json:"providers"`
+}
+
+// StatusReporter reports the status of the providers.
+type StatusReporter interface {
+	ProvidersStatus() ProvidersStatus
+}
+
+// ProvidersStatus returns the status of every provider of the component, including the ones not loaded yet.
+func (tec *TimeExpirationCacher) ProvidersStatus() ProvidersStatus {
+	readiness := tec.Readiness()
+	state := tec.GetState()
+	status := ProvidersStatus{Providers: make(map[Provider]ProviderReport, len(readiness.Providers))}
+	for p, pr := range readiness.Providers {
+		ps := state.ProviderStatus(p)
+		status.Providers[p] = ProviderReport{
+			Fails:       state.Fails(p),
+			Health:      state.Health(p),
+			Readiness:   pr,
+			Items:       state.Available(p),
+			Failures:    ps.Failures,
+			LastSuccess: timeOrNil(ps.LastSuccess),
+			LastError:   ps.LastError,
+			NextRefresh: timeOrNil(ps.NextAttempt),
+		}
+	}
+	return status
+}
+
+// timeOrNil returns nil for the zero time, so it is reported as null.
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func writeHealthResponse(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte("ok")); err != nil {
+		log.Println("error when trying to write data to HTTP response: " + err.Error())
+	}
+}
+
+func writeProvidersStatusResponse(w http.ResponseWriter, status ProvidersStatus) {
+	bb, err := json.Marshal(status)
+	if err != nil {
+		writeErrorResponse(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(bb); err != nil {
+		log.Println("error when trying to write data to HTTP response: " + err.Error())
+	}
+}
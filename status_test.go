@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeExpirationCacher_ProvidersStatus(t *testing.T) {
+	clock := newFakeClock()
+	start := clock.Now()
+	client := hangingContentProvider{release: make(chan struct{})}
+	defer close(client.release)
+	cacher := newTestCacher(t, map[Provider]ProviderConfig{
+		Provider1: {expiration: time.Minute, length: 10, client: SampleContentProvider{Provider1}},
+		Provider2: {expiration: time.Minute, length: 10, client: failedContentProvider{}},
+		Provider3: {expiration: time.Minute, length: 10, client: client},
+	}, WithClock(clock))
+	cacher.Start()
+	defer cacher.Stop()
+	clock.BlockUntil(2)
+	next := start.Add(time.Minute)
+	assert.Equal(t, ProvidersStatus{Providers: map[Provider]ProviderReport{
+		Provider1: {
+			Health:      HealthOK,
+			Readiness:   ReadinessLoaded,
+			Items:       10,
+			LastSuccess: &start,
+			NextRefresh: &next,
+		},
+		Provider2: {
+			Fails:       true,
+			Health:      HealthFailed,
+			Readiness:   ReadinessFailed,
+			Failures:    1,
+			LastError:   "network error",
+			NextRefresh: &next,
+		},
+		Provider3: {
+			Health:    HealthOK,
+			Readiness: ReadinessLoading,
+		},
+	}}, cacher.ProvidersStatus())
+}
+
+func TestProbeEndpoints(t *testing.T) {
+	cacher := newTestCacher(t, map[Provider]ProviderConfig{
+		Provider1: {expiration: time.Minute, length: 10, client: SampleContentProvider{Provider1}},
+	})
+	cacher.Start()
+	defer cacher.Stop()
+	<-cacher.Ready()
+	app := App{Service: MakeService(cacher, MakeConfiguredSequencer(ContentMix{config1})), Readiness: cacher, Status: cacher}
+
+	t.Run("health", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		app.ServeHTTP(response, httptest.NewRequest(http.MethodGet, healthPath, nil))
+		assert.Equal(t, http.StatusOK, response.Code)
+	})
+	t.Run("providers status", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		app.ServeHTTP(response, httptest.NewRequest(http.MethodGet, providersStatusPath, nil))
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "application/json", response.Header().Get("Content-Type"))
+		var status map[string]map[Provider]map[string]interface{}
+		assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &status))
+		report := status["providers"][Provider1]
+		assert.Equal(t, false, report["fails"])
+		assert.Equal(t, float64(10), report["items"])
+		assert.NotNil(t, report["last_success"])
+		assert.NotNil(t, report["next_refresh"])
+	})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// maxWeightsSum limits the size of the block the WeightedSequencer shuffles.
+const maxWeightsSum = 10000
+
+// WeightedMix is the content mix giving every provider the share of the items proportional to its weight,
+// in the random order defined by the seed.
+type WeightedMix struct {
+	Seed    int64            `json:"seed"`
+	Weights []WeightedConfig `json:"weights"`
+}
+
+// WeightedConfig is the provider of the WeightedMix with its weight and fallbacks.
+type WeightedConfig struct {
+	ContentConfig
+	Weight int `json:"weight"`
+}
+
+func (wm WeightedMix) validate(providers map[Provider]ProviderSettings) (problems []string) {
+	if len(wm.Weights) == 0 {
+		problems = append(problems, "weighted mix has no weights")
+	}
+	sum := 0
+	for i, wc := range wm.Weights {
+		if wc.Weight <= 0 {
+			problems = append(problems, fmt.Sprintf("weighted mix %d: weight should be positive", i))
+		} else {
+			sum += wc.Weight
+		}
+		for _, problem := range wc.validate(providers) {
+			problems = append(problems, fmt.Sprintf("weighted mix %d: %s", i, problem))
+		}
+	}
+	if sum > maxWeightsSum {
+		problems = append(problems, fmt.Sprintf("weighted mix: the sum of the weights should not exceed %d", maxWeightsSum))
+	}
+	return
+}
+
+// WeightedSequencer is the strategy ordering the items randomly with the shares given by the weights.
+// The items go in the blocks of the sum of the weights size, every block has each provider as many times as its weight,
+// and the order in the block is shuffled by the seed and the number of the block.
+// So the sequence is the same for the same seed, the pages do not overlap,
+// and the fail/fallback rules of the ConfiguredSequencer apply to every slot.
+type WeightedSequencer struct {
+	seed int64
+	// block are the slots of the block before the shuffle.
+	block []slot
+}
+
+// MakeWeightedSequencer the constructor for the WeightedSequencer
+func MakeWeightedSequencer(mix WeightedMix) WeightedSequencer {
+	var block []slot
+	for _, wc := range mix.Weights {
+		chain := wc.chain()
+		for i := 0; i < wc.Weight; i++ {
+			block = append(block, slot{chain: chain, onFailure: wc.OnFailure.or(PolicyTruncate)})
+		}
+	}
+	return WeightedSequencer{
+		seed:  mix.Seed,
+		block: block,
+	}
+}
+
+// Sequence constructs the page of content addresses (provider+index) having the weights,
+// the information about the failing providers and the offset+limit.
+func (ws WeightedSequencer) Sequence(state FailsState, limit, offset int) ([]ContentAddress, error) {
+	page, err := sequence(ws, state, limit, offset)
+	return page.Addresses, err
+}
+
+// SequencePage constructs the page the same way as Sequence, and reports the slots of the page which could not be served.
+func (ws WeightedSequencer) SequencePage(state FailsState, limit, offset int) (Page, error) {
+	return sequence(ws, state, limit, offset)
+}
+
+func (ws WeightedSequencer) prefix() []slot {
+	return nil
+}
+
+func (ws WeightedSequencer) length() int {
+	return len(ws.block)
+}
+
+func (ws WeightedSequencer) slots(n int) []slot {
+	slots := make([]slot, len(ws.block))
+	copy(slots, ws.block)
+	rnd := rand.New(rand.NewSource(blockSeed(ws.seed, n)))
+	rnd.Shuffle(len(slots), func(i, j int) {
+		slots[i], slots[j] = slots[j], slots[i]
+	})
+	return slots
+}
+
+// blockSeed mixes the seed with the number of the block, so the neighbour seeds do not give the shifted sequences.
+func blockSeed(seed int64, block int) int64 {
+	x := uint64(seed) ^ (uint64(block)+1)*0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return int64(x ^ (x >> 31))
+}
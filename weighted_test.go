@@ -0,0 +1,103 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testWeightedMix = WeightedMix{
+	Seed: 42,
+	Weights: []WeightedConfig{
+		{ContentConfig: ContentConfig{Type: Provider1, Fallback: &Provider2}, Weight: 5},
+		{ContentConfig: ContentConfig{Type: Provider2, Fallback: &Provider3}, Weight: 3},
+		{ContentConfig: ContentConfig{Type: Provider3}, Weight: 2},
+	},
+}
+
+func TestWeightedSequencer_Sequence(t *testing.T) {
+	t.Run("every block has the shares of the weights", func(t *testing.T) {
+		addresses, err := MakeWeightedSequencer(testWeightedMix).Sequence(testFailsState{}, 100, 0)
+		assert.NoError(t, err)
+		assert.Len(t, addresses, 100)
+		for block := 0; block < 10; block++ {
+			counts := make(map[Provider]int)
+			for _, address := range addresses[block*10 : block*10+10] {
+				counts[address.Provider]++
+			}
+			assert.Equal(t, map[Provider]int{Provider1: 5, Provider2: 3, Provider3: 2}, counts)
+		}
+		indexes := make(map[Provider]int)
+		for _, address := range addresses {
+			assert.Equal(t, indexes[address.Provider], address.Index)
+			indexes[address.Provider]++
+		}
+	})
+	t.Run("the same seed gives the same sequence", func(t *testing.T) {
+		first, err := MakeWeightedSequencer(testWeightedMix).Sequence(testFailsState{}, 30, 0)
+		assert.NoError(t, err)
+		second, err := MakeWeightedSequencer(testWeightedMix).Sequence(testFailsState{}, 30, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, first, second)
+
+		mix := testWeightedMix
+		mix.Seed = 43
+		other, err := MakeWeightedSequencer(mix).Sequence(testFailsState{}, 30, 0)
+		assert.NoError(t, err)
+		assert.NotEqual(t, first, other)
+	})
+	t.Run("a provider fails", func(t *testing.T) {
+		state := testFailsState{fails: map[Provider]bool{Provider2: true}}
+		addresses, err := MakeWeightedSequencer(testWeightedMix).Sequence(state, 10, 0)
+		assert.NoError(t, err)
+		counts := make(map[Provider]int)
+		for _, address := range addresses {
+			counts[address.Provider]++
+		}
+		assert.Equal(t, map[Provider]int{Provider1: 5, Provider3: 5}, counts)
+	})
+	t.Run("a provider fails, fallback nil", func(t *testing.T) {
+		state := testFailsState{fails: map[Provider]bool{Provider3: true}}
+		sequencer := MakeWeightedSequencer(testWeightedMix)
+		addresses, err := sequencer.Sequence(state, 10, 0)
+		assert.NoError(t, err)
+		all, err := sequencer.Sequence(testFailsState{}, 10, 0)
+		assert.NoError(t, err)
+		for i, address := range all {
+			if address.Provider == Provider3 {
+				assert.Equal(t, all[:i], addresses)
+				break
+			}
+		}
+	})
+	t.Run("incorrect limit error", func(t *testing.T) {
+		_, err := MakeWeightedSequencer(testWeightedMix).Sequence(testFailsState{}, -1, 0)
+		assert.Error(t, err)
+	})
+}
+
+func TestWeightedSequencer_SequencePages(t *testing.T) {
+	providers := []Provider{Provider1, Provider2, Provider3}
+	rnd := rand.New(rand.NewSource(42))
+	for n := 0; n < 300; n++ {
+		mix := WeightedMix{Seed: rnd.Int63()}
+		for _, wc := range randomMix(rnd, providers) {
+			mix.Weights = append(mix.Weights, WeightedConfig{ContentConfig: wc, Weight: 1 + rnd.Intn(5)})
+		}
+		sequencer := MakeWeightedSequencer(mix)
+		state := randomState(rnd, providers)
+		limit, offset := rnd.Intn(30), rnd.Intn(200)
+		all, err := sequencer.Sequence(state, offset+limit, 0)
+		assert.NoError(t, err)
+		page, err := sequencer.Sequence(state, limit, offset)
+		assert.NoError(t, err)
+		expected := []ContentAddress{}
+		if len(all) > offset {
+			expected = all[offset:]
+		}
+		if !assert.Equal(t, expected, page) {
+			t.Fatalf("mix %+v, fails %v, available %v, limit %d, offset %d", mix, state.fails, state.available, limit, offset)
+		}
+	}
+}